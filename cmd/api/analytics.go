@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+)
+
+// recordSearch stores a search for the analytics in the background, so it never slows
+// down the response, and returns the search id to hand to the client. Only the first
+// page of a search with an actual query is recorded: browsing through later pages
+// isn't a new search. An empty string is returned when nothing is recorded.
+func (app *application) recordSearch(r *http.Request, event *data.SearchEvent, page int) string {
+	if page != 1 || strings.TrimSpace(event.Query) == "" {
+		return ""
+	}
+
+	searchID, err := data.NewSearchID()
+	if err != nil {
+		app.logError(r, err)
+		return ""
+	}
+
+	event.SearchID = searchID
+	event.UserID = app.contextGetUser(r).ID
+
+	app.background(func() {
+		err := app.models.Analytics.RecordSearch(event)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"search_id": searchID})
+		}
+	})
+
+	return searchID
+}
+
+// searchQuery returns the text the user searched for.
+func searchQuery(filters data.Filters) string {
+	if filters.ISBN != "" {
+		return filters.ISBN
+	}
+	return filters.Searchword
+}
+
+// searchFilters returns the query string parameters of a search, except the query text
+// and the paging, as a JSON object.
+func searchFilters(qs url.Values) string {
+	filters := make(map[string]string)
+	for key := range qs {
+		switch key {
+		case "searchword", "typesearch", "page", "page_size", "limit":
+			continue
+		}
+		filters[key] = qs.Get(key)
+	}
+
+	js, err := json.Marshal(filters)
+	if err != nil {
+		return "{}"
+	}
+	return string(js)
+}
+
+func (app *application) createSearchClickHandler(w http.ResponseWriter, r *http.Request) {
+	// Anyone can report clicks, so keep a single client from skewing the click
+	// through rates.
+	allowed, retryAfter := app.clickLimiter.allow(clientIP(r))
+	if !allowed {
+		app.rateLimitExceededResponse(w, r, retryAfter)
+		return
+	}
+
+	var input data.SearchClick
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateSearchClick(v, &input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Analytics.RecordClick(&input)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("search_id", "must be the id of a search")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "click recorded"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readReportFilters reads the from, to (both YYYY-MM-DD, to is inclusive) and limit
+// parameters of the analytics reports. The default is the last 30 days.
+func (app *application) readReportFilters(qs url.Values, v *validator.Validator) data.ReportFilters {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	readDate := func(key string, defaultValue time.Time) time.Time {
+		s := qs.Get(key)
+		if s == "" {
+			return defaultValue
+		}
+
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			v.AddError(key, "must be a date in the YYYY-MM-DD format")
+			return defaultValue
+		}
+		return t
+	}
+
+	return data.ReportFilters{
+		From:  readDate("from", today.AddDate(0, 0, -29)),
+		To:    readDate("to", today).AddDate(0, 0, 1),
+		Limit: app.readInt(qs, "limit", 50, v),
+	}
+}
+
+// The report handlers all share the same shape, so they're built by one function.
+func (app *application) searchReportHandler(report string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+
+		filters := app.readReportFilters(r.URL.Query(), v)
+
+		if data.ValidateReportFilters(v, filters); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		var result interface{}
+		var err error
+
+		switch report {
+		case "top-queries":
+			result, err = app.models.Analytics.TopQueries(filters)
+		case "zero-results":
+			result, err = app.models.Analytics.ZeroResultQueries(filters)
+		case "click-through":
+			result, err = app.models.Analytics.ClickThrough(filters)
+		}
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env := envelope{
+			"report": result,
+			"from":   filters.From.Format("2006-01-02"),
+			"to":     filters.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		}
+
+		err = app.writeJSON(w, http.StatusOK, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
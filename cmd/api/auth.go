@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/jwt"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+)
+
+// The API runs in one of two token modes, picked with the -auth-mode flag:
+//
+//   - opaque: access tokens are random strings stored in the tokens table, and every
+//     authenticated request looks up the user in MySQL.
+//   - jwt: access tokens are signed tokens (see internal/jwt) carrying the user id,
+//     the activation status and the permissions, so requests are authenticated without
+//     touching MySQL. Refresh tokens stay opaque and stored in both modes.
+//
+// Signed tokens can't be deleted, so logging out puts their id on a revocation list,
+// which every server keeps a copy of in memory. Revoking all sessions of a user
+// (logging out everywhere, changing or resetting the password, role and permission
+// changes) puts the user on a second list instead, which rejects their tokens issued
+// before then. Token timestamps have a precision of a second, so a token signed in the
+// same second as the revocation isn't caught.
+const (
+	authModeOpaque = "opaque"
+	authModeJWT    = "jwt"
+)
+
+// revocationSyncInterval is how often the revocation list is reloaded from MySQL, so
+// tokens revoked through other servers are picked up.
+const revocationSyncInterval = 10 * time.Second
+
+// authenticateToken returns the user an access token belongs to. In jwt mode the user
+// only has its ID and Activated fields set, and the claims of the token are returned
+// as well. data.ErrRecordNotFound is returned for invalid, expired or revoked tokens.
+func (app *application) authenticateToken(r *http.Request, token string) (*data.User, *jwt.Claims, error) {
+	if app.jwt == nil {
+		v := validator.New()
+
+		if data.ValidateTokenPlainText(v, token); !v.Valid() {
+			return nil, nil, data.ErrRecordNotFound
+		}
+
+		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Keep track of where and when the token is used, for the session list.
+		err = app.models.Tokens.Touch(token, r.UserAgent(), clientIP(r))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return user, nil, nil
+	}
+
+	claims, err := app.jwt.Verify(token, time.Now())
+	if err != nil {
+		return nil, nil, data.ErrRecordNotFound
+	}
+
+	if app.revoked.contains(claims.ID) {
+		return nil, nil, data.ErrRecordNotFound
+	}
+
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || id < 1 {
+		return nil, nil, data.ErrRecordNotFound
+	}
+
+	if app.revoked.containsUser(id, claims.IssuedAt) {
+		return nil, nil, data.ErrRecordNotFound
+	}
+
+	return &data.User{ID: id, Activated: claims.Activated}, claims, nil
+}
+
+// newSession logs the user in, returning an access token and a refresh token.
+func (app *application) newSession(r *http.Request, user *data.User) (*data.Token, *data.Token, error) {
+	if app.jwt == nil {
+		return app.models.Tokens.NewSession(user.ID, app.config.auth.accessTTL, app.config.auth.refreshTTL, r.UserAgent(), clientIP(r))
+	}
+
+	_, refresh, err := app.models.Tokens.NewSession(user.ID, 0, app.config.auth.refreshTTL, r.UserAgent(), clientIP(r))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, err := app.signAccessToken(user, refresh.Family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// refreshSession exchanges a refresh token for a new access token and refresh token.
+// When the refresh token was used before, the session is revoked, including the signed
+// access tokens issued to it, and data.ErrTokenReused is returned.
+func (app *application) refreshSession(r *http.Request, refreshPlaintext string) (*data.Token, *data.Token, error) {
+	var accessTTL time.Duration
+	if app.jwt == nil {
+		accessTTL = app.config.auth.accessTTL
+	}
+
+	access, refresh, err := app.models.Tokens.Refresh(refreshPlaintext, accessTTL, app.config.auth.refreshTTL, r.UserAgent(), clientIP(r))
+	if err != nil {
+		// Whoever holds the copy may have got signed access tokens with it already,
+		// which don't go away with the session. There's no telling which of them are
+		// the session's, so all of the user's are revoked.
+		if errors.Is(err, data.ErrTokenReused) {
+			if revokeErr := app.revokeSignedTokens(refresh.UserID); revokeErr != nil {
+				return nil, nil, revokeErr
+			}
+		}
+		return nil, nil, err
+	}
+
+	if app.jwt == nil {
+		return access, refresh, nil
+	}
+
+	// Read the user again, the activation status and permissions may have changed
+	// since the last token was signed.
+	user, err := app.models.Users.Get(refresh.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, err = app.signAccessToken(user, refresh.Family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+func (app *application) signAccessToken(user *data.User, family string) (*data.Token, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.auth.accessTTL)
+
+	plaintext, err := app.jwt.Sign(jwt.Claims{
+		ID:          hex.EncodeToString(jti),
+		Subject:     strconv.FormatInt(user.ID, 10),
+		IssuedAt:    now.Unix(),
+		Expiry:      expiry.Unix(),
+		Activated:   user.Activated,
+		Permissions: permissions,
+		Session:     family,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		Plaintext: plaintext,
+		UserID:    user.ID,
+		Expiry:    expiry,
+		Scope:     data.ScopeAuthentication,
+		Family:    family,
+	}, nil
+}
+
+// revokeRequestToken revokes the access token the request was made with, along with
+// its refresh token.
+func (app *application) revokeRequestToken(r *http.Request) error {
+	claims := app.contextGetClaims(r)
+	if claims == nil {
+		return app.models.Tokens.Delete(app.contextGetToken(r))
+	}
+
+	expiry := time.Unix(claims.Expiry, 0)
+
+	err := app.models.Tokens.Revoke(claims.ID, expiry)
+	if err != nil {
+		return err
+	}
+	app.revoked.add(claims.ID, expiry)
+
+	return app.models.Tokens.DeleteFamily(claims.Session)
+}
+
+// revokeOtherSessions logs the authenticated user out of every session except the one
+// the request was made with. In jwt mode the signed access token of the request is
+// revoked as well, the client gets a new one with the refresh token.
+func (app *application) revokeOtherSessions(r *http.Request) error {
+	var currentFamily string
+	if claims := app.contextGetClaims(r); claims != nil {
+		currentFamily = claims.Session
+	}
+
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteOtherSessions(user.ID, app.contextGetToken(r), currentFamily)
+	if err != nil {
+		return err
+	}
+
+	return app.revokeSignedTokens(user.ID)
+}
+
+// revokeSignedTokens revokes the signed access tokens the user has been issued so far,
+// in jwt mode. Access tokens are the only thing which has to be revoked for changes to
+// the user's permissions to take effect: the refresh tokens stay valid and the next
+// access token is signed with the new permissions.
+func (app *application) revokeSignedTokens(userID int64) error {
+	if app.jwt == nil {
+		return nil
+	}
+
+	now := time.Now()
+
+	revoked := data.RevokedUser{
+		Before: now.Truncate(time.Second),
+		Expiry: now.Add(app.config.auth.accessTTL),
+	}
+
+	err := app.models.Tokens.RevokeUser(userID, revoked)
+	if err != nil {
+		return err
+	}
+	app.revoked.addUser(userID, revoked)
+
+	return nil
+}
+
+// loadUser returns the full record of the authenticated user. In opaque mode that's the
+// user in the request context, in jwt mode it has to be read from the database.
+func (app *application) loadUser(r *http.Request) (*data.User, error) {
+	claims := app.contextGetClaims(r)
+	if claims == nil {
+		return app.contextGetUser(r), nil
+	}
+
+	return app.models.Users.Get(app.contextGetUser(r).ID)
+}
+
+// userPermissions returns the permissions of the authenticated user, from the access
+// token in jwt mode. Permissions put in the request context by requirePermission are
+// used instead of looking them up again.
+func (app *application) userPermissions(r *http.Request) (data.Permissions, error) {
+	if permissions, ok := app.contextGetPermissions(r); ok {
+		return permissions, nil
+	}
+
+	claims := app.contextGetClaims(r)
+	if claims != nil {
+		return data.Permissions(claims.Permissions), nil
+	}
+
+	return app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+}
+
+// revocationList is the in-memory copy of the revoked_tokens and revoked_users tables.
+type revocationList struct {
+	mu      sync.RWMutex
+	entries map[string]time.Time
+	users   map[int64]data.RevokedUser
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{
+		entries: make(map[string]time.Time),
+		users:   make(map[int64]data.RevokedUser),
+	}
+}
+
+func (l *revocationList) contains(jti string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	expiry, ok := l.entries[jti]
+	return ok && time.Now().Before(expiry)
+}
+
+// containsUser reports whether the tokens of the user issued at issuedAt (a Unix time)
+// are revoked.
+func (l *revocationList) containsUser(userID int64, issuedAt int64) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	revoked, ok := l.users[userID]
+	return ok && time.Now().Before(revoked.Expiry) && issuedAt < revoked.Before.Unix()
+}
+
+func (l *revocationList) addUser(userID int64, revoked data.RevokedUser) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if current, ok := l.users[userID]; ok && current.Before.After(revoked.Before) {
+		return
+	}
+	l.users[userID] = revoked
+}
+
+func (l *revocationList) add(jti string, expiry time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[jti] = expiry
+}
+
+// merge adds the entries loaded from the database and forgets the expired ones.
+// Revocations are never undone, so entries missing from the database (because they
+// were added while it was being read) are kept.
+func (l *revocationList) merge(entries map[string]time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	for jti, expiry := range l.entries {
+		if now.Before(expiry) {
+			if _, ok := entries[jti]; !ok {
+				entries[jti] = expiry
+			}
+		}
+	}
+
+	l.entries = entries
+}
+
+// mergeUsers is merge for the per-user entries. An entry added locally is kept while
+// it's later than the one in the database.
+func (l *revocationList) mergeUsers(users map[int64]data.RevokedUser) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	for userID, revoked := range l.users {
+		if !now.Before(revoked.Expiry) {
+			continue
+		}
+		if loaded, ok := users[userID]; !ok || revoked.Before.After(loaded.Before) {
+			users[userID] = revoked
+		}
+	}
+
+	l.users = users
+}
+
+// syncRevocations reloads the revocation list until ctx is cancelled.
+func (app *application) syncRevocations(ctx context.Context) {
+	ticker := time.NewTicker(revocationSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		revoked, err := app.models.Tokens.GetRevoked()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		} else {
+			app.revoked.merge(revoked)
+		}
+
+		users, err := app.models.Tokens.GetRevokedUsers()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		} else {
+			app.revoked.mergeUsers(users)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
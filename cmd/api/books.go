@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/isbn"
 	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
 )
 
@@ -26,6 +31,9 @@ func (app *application) listBooksHandler (w http.ResponseWriter, r *http.Request
 	input.Page = app.readInt(qs, "page", 1, v)
 	input.PageSize = app.readInt(qs, "page_size", 24, v)
 	input.ISBN = app.readString(qs, "isbn", "")
+	input.Highlight = app.readBool(qs, "highlight", false, v)
+	input.Sort = app.readString(qs, "sort", "relevance")
+	input.SortSafelist = []string{"relevance", "rating"}
 
 	// execute validation check on the Filters struct
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
@@ -37,13 +45,15 @@ func (app *application) listBooksHandler (w http.ResponseWriter, r *http.Request
 	var metadata data.Metadata
 	var err error
 
-	// validate book isbn (with 3rd party bcz i'm lazy to write on my own)
+	start := time.Now()
+
+	// validate book isbn and convert it to the canonical ISBN-13 form, so
+	// ISBN-10s, ISBN-13s and hyphenated ISBNs all find the same book.
 	// if isbn is not valid then set isbn value to empty string. This will 
 	// determined query that will be used to query the books. (if isbn valid
-	// we will only query identifier field on Elasticsearch, otherwise we
+	// we will only query the normalized ISBN field on Elasticsearch, otherwise we
 	// are going to use searchword field for query)
-	thirdPartyValidator := validator.NewGoPlayground()
-	err = thirdPartyValidator.Var(input.ISBN, "isbn")
+	input.ISBN, err = isbn.Parse(input.ISBN)
 	if err != nil {
 		input.ISBN = ""
 	}
@@ -76,6 +86,17 @@ func (app *application) listBooksHandler (w http.ResponseWriter, r *http.Request
 		}	
 	}
 
+	// Record the search for the analytics, the search id lets the client report
+	// which result was clicked.
+	metadata.SearchID = app.recordSearch(r, &data.SearchEvent{
+		Kind:        data.SearchKindSearch,
+		Query:       searchQuery(input.Filters),
+		Filters:     searchFilters(qs),
+		ResultCount: metadata.TotalRecords,
+		Latency:     time.Since(start),
+		Degraded:    metadata.Degraded,
+	}, input.Page)
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"books": books, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -86,21 +107,46 @@ func (app *application) listBookSuggestionsHandler (w http.ResponseWriter, r *ht
 	// define struct to store query params from request
 	var input struct {
 		Typesearch string
+		Limit      int
 		data.Filters
 	}
 
+	v := validator.New()
+
 	// Call r.URL.Query() to get the url.Values map containing the query string data.
 	qs := r.URL.Query()
 
 	input.Typesearch = app.readString(qs, "typesearch", "")
+	input.Limit = app.readInt(qs, "limit", 5, v)
+	input.Extension = app.readString(qs, "extension", "all")
+	input.Availability = app.readInt(qs, "availability", 0, v)
+
+	v.Check(len(input.Typesearch) <= 200, "typesearch", "must not be more than 200 bytes long")
+	v.Check(input.Limit > 0, "limit", "must be greater than zero")
+	v.Check(input.Limit <= 20, "limit", "must be a maximum of 20")
 
-	books, err := app.models.Books.GetBookSuggestions(input.Typesearch, input.Filters)
+	if data.ValidateAdvanceFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	start := time.Now()
+
+	suggestions, err := app.models.Books.GetBookSuggestions(input.Typesearch, input.Filters, input.Limit)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": books}, nil)
+	searchID := app.recordSearch(r, &data.SearchEvent{
+		Kind:        data.SearchKindSuggest,
+		Query:       input.Typesearch,
+		Filters:     searchFilters(qs),
+		ResultCount: len(suggestions.Titles) + len(suggestions.Authors) + len(suggestions.Publishers),
+		Latency:     time.Since(start),
+	}, 1)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions, "search_id": searchID}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -125,8 +171,279 @@ func (app *application) showBookHandler (w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Point the cover at our cover proxy, like the search results do.
+	book.RewriteCoverURL()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listRelatedBooksHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	// limit is the number of books in each rail.
+	limit := app.readInt(r.URL.Query(), "limit", 8, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 24, "limit", "must be a maximum of 24")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Make sure the book exists, so an unknown id gets a 404 rather than two empty
+	// rails.
+	_, err = app.models.Books.GetBook(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	related, degraded, err := app.models.Books.Related(id, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	alsoBought, err := app.models.Books.AlsoBought(id, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"related": related, "also_bought": alsoBought}
+
+	// Like the search metadata, tell clients the related books are missing because
+	// Elasticsearch is unavailable, not because there are none.
+	if degraded {
+		env["degraded"] = true
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshAlsoBought recomputes the "customers also bought" counts every
+// also-bought-refresh-interval until ctx is cancelled.
+func (app *application) refreshAlsoBought(ctx context.Context) {
+	ticker := time.NewTicker(app.config.alsoBought.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		err := app.models.Books.RefreshAlsoBought()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request) {
+	// Declare an anonymous struct to hold the information that we expect to be in the
+	// HTTP request body.
+	var input struct {
+		Title      string `json:"title"`
+		Author     string `json:"author"`
+		CoverUrl   string `json:"coverurl"`
+		Extension  string `json:"extension"`
+		Year       string `json:"year"`
+		Publisher  string `json:"publisher"`
+		Language   string `json:"language"`
+		Identifier string `json:"identifier"`
+		Quantity   int    `json:"stock"`
+		Price      int64  `json:"price"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Copy the values from the input struct to a new Book struct.
+	book := &data.Book{
+		Title:      &input.Title,
+		Author:     &input.Author,
+		CoverUrl:   &input.CoverUrl,
+		Extension:  &input.Extension,
+		Year:       &input.Year,
+		Publisher:  &input.Publisher,
+		Language:   &input.Language,
+		Identifier: &input.Identifier,
+		Quantity:   input.Quantity,
+		Price:      input.Price,
+	}
+
+	v := validator.New()
+
+	if data.ValidateBook(v, book); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Books.Insert(book)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Include a Location header to let the client know which URL they can find the
+	// newly-created resource at.
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/books/detail/%d", book.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"book": book}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	book, err := app.models.Books.GetBook(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If the request contains a X-Expected-Version header, verify that the book
+	// version in the database matches the expected version specified in the header.
+	if r.Header.Get("X-Expected-Version") != "" {
+		if strconv.FormatInt(int64(book.Version), 10) != r.Header.Get("X-Expected-Version") {
+			app.editConlictResponse(w, r)
+			return
+		}
+	}
+
+	// Use pointers for the fields so we can tell apart the fields that weren't
+	// provided in the request body (nil) from the ones set to their zero value.
+	var input struct {
+		Title      *string `json:"title"`
+		Author     *string `json:"author"`
+		CoverUrl   *string `json:"coverurl"`
+		Extension  *string `json:"extension"`
+		Year       *string `json:"year"`
+		Publisher  *string `json:"publisher"`
+		Language   *string `json:"language"`
+		Identifier *string `json:"identifier"`
+		Quantity   *int    `json:"stock"`
+		Price      *int64  `json:"price"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Only copy the fields that were actually provided.
+	if input.Title != nil {
+		book.Title = input.Title
+	}
+	if input.Author != nil {
+		book.Author = input.Author
+	}
+	if input.CoverUrl != nil {
+		book.CoverUrl = input.CoverUrl
+	}
+	if input.Extension != nil {
+		book.Extension = input.Extension
+	}
+	if input.Year != nil {
+		book.Year = input.Year
+	}
+	if input.Publisher != nil {
+		book.Publisher = input.Publisher
+	}
+	if input.Language != nil {
+		book.Language = input.Language
+	}
+	if input.Identifier != nil {
+		book.Identifier = input.Identifier
+	}
+	if input.Quantity != nil {
+		book.Quantity = *input.Quantity
+	}
+	if input.Price != nil {
+		book.Price = *input.Price
+	}
+
+	v := validator.New()
+
+	if data.ValidateBook(v, book); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Books.Update(book)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConlictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
-}
\ No newline at end of file
+}
+
+func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Books.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrBookOrdered):
+			message := "the book has been ordered and can't be deleted, set its stock to 0 instead"
+			app.errorResponse(w, r, http.StatusConflict, message)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "book successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
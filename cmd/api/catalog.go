@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/catalog"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+)
+
+// importCatalogHandler takes a CSV or JSON Lines file as the raw request body and
+// imports it with the same importer as the cmd/catalog-import command. The format
+// comes from the "format" query parameter, or failing that from the Content-Type
+// header. Use the command for very large files, the request is bound by the server's
+// write timeout.
+func (app *application) importCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	format := app.readString(qs, "format", catalog.FormatFromName(r.Header.Get("Content-Type")))
+	dryRun := app.readBool(qs, "dry_run", false, v)
+
+	v.Check(validator.In(format, catalog.FormatCSV, catalog.FormatJSONL), "format", "must be csv or jsonl")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Limit the size of the uploaded file to 50MB.
+	r.Body = http.MaxBytesReader(w, r.Body, 50<<20)
+
+	reader, err := catalog.NewReader(format, r.Body)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	importer := catalog.Importer{
+		Models: app.models,
+		DryRun: dryRun,
+	}
+
+	report, err := importer.Import(reader)
+	if err != nil {
+		// An oversized body surfaces as a read error half way through the import.
+		if err.Error() == "http: request body too large" {
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, envelope{"message": "body must not be larger than 50MB", "report": report})
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
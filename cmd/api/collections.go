@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	// Catalog admins can ask for the collections outside their schedule window too,
+	// to check what's coming up.
+	includeInactive := app.readBool(r.URL.Query(), "include_inactive", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if includeInactive {
+		admin, err := app.hasPermission(r, "books:write")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !admin {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	collections, err := app.models.Collections.GetAll(includeInactive)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collections": collections}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCollectionBooksHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 24, v)
+	input.Sort = "position"
+	input.SortSafelist = []string{"position"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	collection, ok := app.readCollection(w, r)
+	if !ok {
+		return
+	}
+
+	// Collections outside their schedule window don't exist as far as the storefront
+	// is concerned.
+	if !collection.Active(time.Now()) {
+		admin, err := app.hasPermission(r, "books:write")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !admin {
+			app.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	books, metadata, err := app.models.Collections.GetBooks(collection.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection, "books": books, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Slug        string     `json:"slug"`
+		Title       string     `json:"title"`
+		Description string     `json:"description"`
+		CoverBookID *int64     `json:"cover_book_id"`
+		Position    int        `json:"position"`
+		StartsAt    *time.Time `json:"starts_at"`
+		EndsAt      *time.Time `json:"ends_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	collection := &data.Collection{
+		Slug:        input.Slug,
+		Title:       input.Title,
+		Description: input.Description,
+		CoverBookID: input.CoverBookID,
+		Position:    input.Position,
+		StartsAt:    input.StartsAt,
+		EndsAt:      input.EndsAt,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Insert(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a collection with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUnknownCoverBook):
+			v.AddError("cover_book_id", "must be the id of an existing book")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", "/v1/collections/"+collection.Slug+"/books")
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"collection": collection}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readCollection(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("X-Expected-Version") != "" {
+		if strconv.FormatInt(int64(collection.Version), 10) != r.Header.Get("X-Expected-Version") {
+			app.editConlictResponse(w, r)
+			return
+		}
+	}
+
+	// The cover book and schedule fields are kept raw, so we can tell an absent field (leave the
+	// value alone) from null (clear it).
+	var input struct {
+		Slug        *string         `json:"slug"`
+		Title       *string         `json:"title"`
+		Description *string         `json:"description"`
+		CoverBookID json.RawMessage `json:"cover_book_id"`
+		Position    *int            `json:"position"`
+		StartsAt    json.RawMessage `json:"starts_at"`
+		EndsAt      json.RawMessage `json:"ends_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Slug != nil {
+		collection.Slug = *input.Slug
+	}
+	if input.Title != nil {
+		collection.Title = *input.Title
+	}
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+	if input.Position != nil {
+		collection.Position = *input.Position
+	}
+
+	err = readNullableInt(input.CoverBookID, &collection.CoverBookID)
+	if err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("body contains badly-formed cover_book_id: %v", err))
+		return
+	}
+
+	err = readNullableTime(input.StartsAt, &collection.StartsAt)
+	if err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("body contains badly-formed starts_at: %v", err))
+		return
+	}
+
+	err = readNullableTime(input.EndsAt, &collection.EndsAt)
+	if err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("body contains badly-formed ends_at: %v", err))
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Update(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a collection with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUnknownCoverBook):
+			v.AddError("cover_book_id", "must be the id of an existing book")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConlictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readCollection(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Collections.Delete(collection.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "collection successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setCollectionBooksHandler replaces the books of a collection with the ids in the
+// request body, in display order.
+func (app *application) setCollectionBooksHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readCollection(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		BookIDs []int64 `json:"book_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateCollectionBooks(v, input.BookIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.SetBooks(collection.ID, input.BookIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("book_ids", "must only contain ids of existing books")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection, "book_ids": input.BookIDs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readNullableTime decodes a raw JSON time into dst. An empty raw value (the field was
+// absent) leaves dst alone and null sets it to nil.
+func readNullableTime(raw json.RawMessage, dst **time.Time) error {
+	switch {
+	case len(raw) == 0:
+		return nil
+	case string(raw) == "null":
+		*dst = nil
+		return nil
+	}
+
+	var t time.Time
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return err
+	}
+
+	*dst = &t
+	return nil
+}
+
+// readNullableInt decodes a raw JSON integer into dst, like readNullableTime.
+func readNullableInt(raw json.RawMessage, dst **int64) error {
+	switch {
+	case len(raw) == 0:
+		return nil
+	case string(raw) == "null":
+		*dst = nil
+		return nil
+	}
+
+	var n int64
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return err
+	}
+
+	*dst = &n
+	return nil
+}
+
+// readCollection fetches the collection named by the slug in the URL. If it doesn't
+// exist, or something goes wrong, the error response is sent and ok is false.
+func (app *application) readCollection(w http.ResponseWriter, r *http.Request) (*data.Collection, bool) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	collection, err := app.models.Collections.GetBySlug(slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return collection, true
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/jwt"
 )
 
 // Define a custome contextKey type, with the underlying type string
@@ -15,6 +16,16 @@ type contextKey string
 // in the request context
 const userContextKey = contextKey("user")
 
+// tokenContextKey holds the plaintext authentication token the request was made with.
+const tokenContextKey = contextKey("token")
+
+// claimsContextKey holds the claims of a signed access token, in the jwt token mode.
+const claimsContextKey = contextKey("claims")
+
+// permissionsContextKey holds the permissions of the user, once they've been looked up
+// for the request.
+const permissionsContextKey = contextKey("permissions")
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
@@ -35,3 +46,45 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// The contextSetToken() method adds the authentication token the request was made with
+// to the request context.
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// The contextGetToken() retrieves the authentication token from the request context,
+// or the empty string for anonymous requests.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+// The contextSetClaims() method adds the claims of a signed access token to the request
+// context.
+func (app *application) contextSetClaims(r *http.Request, claims *jwt.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// The contextGetClaims() retrieves the claims of the signed access token the request
+// was made with. It returns nil for opaque tokens and anonymous requests.
+func (app *application) contextGetClaims(r *http.Request) *jwt.Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(*jwt.Claims)
+	return claims
+}
+
+// The contextSetPermissions() method adds the permissions of the user to the request
+// context, so they're only looked up once per request.
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// The contextGetPermissions() retrieves the permissions of the user from the request
+// context. ok is false when they haven't been looked up yet.
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
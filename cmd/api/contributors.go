@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) listAuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	prefix := app.readString(qs, "prefix", "")
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(prefix != "", "prefix", "must be provided")
+	v.Check(len(prefix) <= 100, "prefix", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 50, "limit", "must be a maximum of 50")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	authors, err := app.models.Books.SuggestContributors(data.ContributorAuthor, prefix, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authors": authors}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	app.showContributor(w, r, data.ContributorAuthor)
+}
+
+func (app *application) showPublisherHandler(w http.ResponseWriter, r *http.Request) {
+	app.showContributor(w, r, data.ContributorPublisher)
+}
+
+// showContributor sends the summary and a page of books of the author or publisher
+// named by the slug in the URL. The envelope key is the contributor kind.
+func (app *application) showContributor(w http.ResponseWriter, r *http.Request, kind string) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 24, v)
+	input.Sort = "year"
+	input.SortSafelist = []string{"year"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Slugs only ever contain what Slugify produces, anything else can't match.
+	if slug == "" || data.Slugify(slug) != slug {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	contributor, books, metadata, err := app.models.Books.GetContributor(kind, slug, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{kind: contributor, "books": books, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/covers"
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+)
+
+func (app *application) showBookCoverHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	// w is the width in pixels; leaving it out returns the original image.
+	width := app.readInt(r.URL.Query(), "w", 0, v)
+	v.Check(app.covers.AllowedWidth(width), "w", "must be one of the supported cover widths")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	book, err := app.models.Books.GetBook(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if book.CoverUrl == nil || *book.CoverUrl == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	cover, err := app.covers.Get(r.Context(), book.ID, *book.CoverUrl, width)
+	if err != nil {
+		switch {
+		case errors.Is(err, covers.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.badGatewayResponse(w, r, err)
+		}
+		return
+	}
+
+	// The ETag changes along with the cover path, so browsers and CDNs can keep the
+	// image for a day and revalidate cheaply afterwards. http.ServeContent() takes
+	// care of If-None-Match, If-Modified-Since and range requests for us.
+	w.Header().Set("Content-Type", cover.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", cover.ETag)
+
+	http.ServeContent(w, r, "", cover.ModTime, bytes.NewReader(cover.Data))
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -227,6 +228,30 @@ func (app *application) readInt64(qs url.Values, key string, defaultValue int64,
 	return i
 }
 
+// The readBool() helper reads a string value from the query string and converts it to a
+// boolean before returning. If no matching key could be found it returns the provided
+// default value. If the value couldn't be converted to a boolean, then we record an
+// error message in the provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	// Extract the value from the query string.
+	s := qs.Get(key)
+
+	// If no key exists (or the value is empty) then return the default value.
+	if s == "" {
+		return defaultValue
+	}
+
+	// Try to convert the value to a bool. If this fails, add an error message to the
+	// validator instance and return the default value.
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
 // readPositiveInt read a string value from the query string and covert it to an integer and write it to dest.
 // If the value is not positive or couldn't be converted to an integer, then we record an
 // error message in the provided Validator instance.
@@ -290,3 +315,28 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// hasPermission reports whether the user in the request context has the given
+// permission. Anonymous users have none.
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return false, nil
+	}
+
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include(code), nil
+}
+
+// clientIP returns the IP address of the client which made the request.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
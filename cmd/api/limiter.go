@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// keyedLimiter allows at most limit events per key in a fixed window. It lives in
+// memory, so the counts are per server instance and are lost on restart, which is
+// good enough for throttling emails.
+type keyedLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*limiterWindow
+	sweptAt time.Time
+}
+
+type limiterWindow struct {
+	start time.Time
+	count int
+}
+
+func newKeyedLimiter(limit int, window time.Duration) *keyedLimiter {
+	return &keyedLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*limiterWindow),
+	}
+}
+
+// allow records an event for key and reports whether it's within the limit. When it
+// isn't, the time until the window resets is returned as well.
+func (l *keyedLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	// Forget expired windows once per window, so the map doesn't grow forever.
+	if now.Sub(l.sweptAt) >= l.window {
+		l.sweptAt = now
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+	}
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &limiterWindow{start: now}
+		l.windows[key] = w
+	}
+
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+
+	w.count++
+	return true, 0
+}
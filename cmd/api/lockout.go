@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+)
+
+// reserveLogin claims a login attempt for the account with the given email and for the
+// client's IP address, see LoginFailureModel.Reserve. It returns how long the client
+// has to wait instead, because of earlier failures of either; that doesn't depend on
+// whether the account exists. locked reports whether the attempt locked the account.
+func (app *application) reserveLogin(r *http.Request, email string) (wait time.Duration, locked bool, err error) {
+	wait, ipLocked, err := app.models.LoginFailures.Reserve(data.LoginFailureIP, clientIP(r), app.config.login.ip)
+	if err != nil || wait > 0 {
+		return wait, false, err
+	}
+
+	if ipLocked {
+		app.logger.PrintInfo("ip address locked out of login", map[string]string{"ip": clientIP(r)})
+	}
+
+	wait, locked, err = app.models.LoginFailures.Reserve(data.LoginFailureAccount, email, app.config.login.account)
+	if err != nil || wait > 0 {
+		// The attempt doesn't happen, so it mustn't count against the IP address.
+		releaseErr := app.models.LoginFailures.Release(data.LoginFailureIP, clientIP(r), app.config.login.ip)
+		if err == nil {
+			err = releaseErr
+		}
+		return wait, false, err
+	}
+
+	return 0, locked, nil
+}
+
+// releaseLogin takes back the attempt reserved for a login which succeeded. The earlier
+// failures of the account are forgotten. The ones of the IP address aren't: an
+// attacker could otherwise reset them by logging in to their own account now and then.
+func (app *application) releaseLogin(r *http.Request, email string) error {
+	err := app.models.LoginFailures.Reset(data.LoginFailureAccount, email)
+	if err != nil {
+		return err
+	}
+
+	return app.models.LoginFailures.Release(data.LoginFailureIP, clientIP(r), app.config.login.ip)
+}
+
+// notifyLoginLockout tells the owner of an account that failed logins locked it. user
+// is nil when no account has the email.
+func (app *application) notifyLoginLockout(r *http.Request, user *data.User) {
+	if user == nil {
+		return
+	}
+
+	ip := clientIP(r)
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"lockoutMinutes": int(app.config.login.account.Lockout.Minutes()),
+			"ip":             ip,
+		}
+
+		err := app.mailer.Send(user.Email, "login_lockout.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
+
+// Lift the lockout of an account and forget its failed logins and wrong two-factor
+// codes.
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.LoginFailures.Reset(data.LoginFailureAccount, user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.LoginFailures.Reset(data.LoginFailureTwoFactor, strconv.FormatInt(user.ID, 10))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
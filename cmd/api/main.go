@@ -4,16 +4,24 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	elasticsearch "github.com/elastic/go-elasticsearch/v7"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/hafizmfadli/hello-nerds-api/internal/covers"
 	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/indexer"
 	"github.com/hafizmfadli/hello-nerds-api/internal/jsonlog"
+	"github.com/hafizmfadli/hello-nerds-api/internal/jwt"
 	"github.com/hafizmfadli/hello-nerds-api/internal/mailer"
+	"github.com/hafizmfadli/hello-nerds-api/internal/oidc"
 )
 
 const version = "1.0.0"
@@ -25,6 +33,57 @@ type config struct {
 		dsn string
 	}
 	es elasticsearch.Config
+	esIndex string
+	// sync configures the in-process synchronizer which drains the books outbox into
+	// Elasticsearch.
+	sync struct {
+		enabled bool
+		interval time.Duration
+		batchSize int
+	}
+	// alsoBought configures the background refresh of the "customers also bought"
+	// counts.
+	alsoBought struct {
+		refreshInterval time.Duration
+	}
+	// rebuild configures the search index rebuilds started through the API.
+	rebuild struct {
+		batchSize int
+		maxDrift int
+	}
+	// covers configures the cover image proxy.
+	covers struct {
+		origin string
+		cacheDir string
+		cacheMaxBytes int64
+		widths []int
+	}
+	// auth configures the lifetime of access tokens and of the refresh tokens used to
+	// get new ones.
+	auth struct {
+		mode string
+		accessTTL time.Duration
+		refreshTTL time.Duration
+		jwtKeys string
+		jwtKeyID string
+	}
+	// oidc configures the OpenID Connect providers users can log in with.
+	oidc struct {
+		providers string
+		redirectURL string
+	}
+	// login configures the throttling of failed logins, per account and per IP address,
+	// and of wrong two-factor codes per user.
+	login struct {
+		account data.LockoutPolicy
+		ip data.LockoutPolicy
+		twoFactor data.LockoutPolicy
+	}
+	// activation configures how often activation emails can be resent to an address.
+	activation struct {
+		resendLimit int
+		resendWindow time.Duration
+	}
 	smtp struct {
 		host string
 		port int
@@ -39,6 +98,19 @@ type application struct {
 	logger *jsonlog.Logger
 	models data.Models
 	mailer mailer.Mailer
+	covers *covers.Service
+	activationLimiter *keyedLimiter
+	clickLimiter *keyedLimiter
+	jwt *jwt.Signer
+	revoked *revocationList
+	oidc map[string]*oidc.Provider
+	// reindexer is the template for search index rebuilds, rebuilding is 1 while one
+	// started by this instance runs.
+	reindexer *indexer.Reindexer
+	rebuilding int32
+	// workerCtx is cancelled when the server starts shutting down, to stop long
+	// running background work like rebuilds.
+	workerCtx context.Context
 	wg sync.WaitGroup
 }
 
@@ -46,11 +118,57 @@ func main() {
 
 	var cfg config
 	var clusterURLs string
+	var coverWidths string
 	
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("HELLO_NERDS_DB_DSN"), "MySQL DSN")
 	flag.StringVar(&clusterURLs, "es-cluster-URLs", "http://127.0.0.1:9200", "Elasticsearch Cluster URLs")
+	flag.StringVar(&cfg.esIndex, "es-index", "books-v1", "Elasticsearch books index alias")
+
+	flag.BoolVar(&cfg.sync.enabled, "sync-enabled", true, "Keep the search index in sync with the catalog")
+	flag.DurationVar(&cfg.sync.interval, "sync-interval", time.Second, "How often to poll the books outbox when it is empty")
+	flag.IntVar(&cfg.sync.batchSize, "sync-batch-size", 500, "Maximum number of outbox entries per Elasticsearch bulk request")
+
+	flag.IntVar(&cfg.rebuild.batchSize, "rebuild-batch-size", 1000, "Number of books per Elasticsearch bulk request when rebuilding the search index")
+	flag.IntVar(&cfg.rebuild.maxDrift, "rebuild-max-drift", indexer.DefaultMaxDrift, "Allowed difference between the catalog and the rebuilt search index document count")
+
+	flag.DurationVar(&cfg.alsoBought.refreshInterval, "also-bought-refresh-interval", time.Hour, "How often to recompute the customers also bought counts (0 disables, for all but one instance)")
+
+	flag.StringVar(&cfg.covers.origin, "cover-origin", "http://library.lol/covers/", "Base URL covers are fetched from, cover paths outside of it are refused")
+	flag.StringVar(&cfg.covers.cacheDir, "cover-cache-dir", filepath.Join(os.TempDir(), "hello-nerds-covers"), "Directory for cached cover images")
+	flag.Int64Var(&cfg.covers.cacheMaxBytes, "cover-cache-max-bytes", 512<<20, "Maximum size of the cover cache in bytes")
+	flag.StringVar(&coverWidths, "cover-widths", "120,240,480", "Comma separated list of cover widths clients may request")
+
+	flag.StringVar(&cfg.auth.mode, "auth-mode", authModeOpaque, "Access token mode (opaque|jwt)")
+	flag.StringVar(&cfg.auth.jwtKeys, "jwt-keys", os.Getenv("HELLO_NERDS_JWT_KEYS"), "Comma separated kid:base64key pairs for signing access tokens in jwt mode")
+	flag.StringVar(&cfg.auth.jwtKeyID, "jwt-key-id", "", "Id of the key new access tokens are signed with in jwt mode")
+	flag.DurationVar(&cfg.auth.accessTTL, "auth-access-ttl", 15*time.Minute, "Lifetime of authentication (access) tokens")
+	flag.DurationVar(&cfg.auth.refreshTTL, "auth-refresh-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+
+	flag.StringVar(&cfg.oidc.providers, "oidc-providers", os.Getenv("HELLO_NERDS_OIDC_PROVIDERS"), "Comma separated name|issuer|client_id|client_secret OpenID Connect providers")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "", "URL OpenID Connect providers send users back to after logging in")
+
+	// The delays between failed logins grow from 1 second to 30 seconds for accounts.
+	// IP addresses are shared by many users (think offices and mobile networks), so by
+	// default they only get the lockout.
+	flag.IntVar(&cfg.login.account.FreeAttempts, "login-free-attempts", 3, "Failed logins of an account before delays between attempts start")
+	flag.DurationVar(&cfg.login.account.BaseDelay, "login-base-delay", time.Second, "First delay between failed logins of an account, doubled on every further failure")
+	flag.DurationVar(&cfg.login.account.MaxDelay, "login-max-delay", 30*time.Second, "Longest delay between failed logins of an account")
+	flag.DurationVar(&cfg.login.account.Window, "login-window", time.Hour, "How long failed logins of an account are remembered")
+	flag.IntVar(&cfg.login.account.Threshold, "login-lockout-threshold", 10, "Failed logins after which an account is locked")
+	flag.DurationVar(&cfg.login.account.Lockout, "login-lockout-duration", 15*time.Minute, "How long an account stays locked")
+	flag.IntVar(&cfg.login.ip.FreeAttempts, "login-ip-free-attempts", 50, "Failed logins of an IP address before delays between attempts start")
+	flag.DurationVar(&cfg.login.ip.BaseDelay, "login-ip-base-delay", 0, "First delay between failed logins of an IP address, doubled on every further failure")
+	flag.DurationVar(&cfg.login.ip.MaxDelay, "login-ip-max-delay", 0, "Longest delay between failed logins of an IP address")
+	flag.DurationVar(&cfg.login.ip.Window, "login-ip-window", time.Hour, "How long failed logins of an IP address are remembered")
+	flag.IntVar(&cfg.login.ip.Threshold, "login-ip-lockout-threshold", 50, "Failed logins after which an IP address is locked")
+	flag.DurationVar(&cfg.login.ip.Lockout, "login-ip-lockout-duration", 15*time.Minute, "How long an IP address stays locked")
+	flag.IntVar(&cfg.login.twoFactor.Threshold, "two-factor-lockout-threshold", 5, "Wrong two-factor codes after which a user's two-factor logins are locked")
+	flag.DurationVar(&cfg.login.twoFactor.Lockout, "two-factor-lockout-duration", 15*time.Minute, "How long a user's two-factor logins stay locked")
+
+	flag.IntVar(&cfg.activation.resendLimit, "activation-resend-limit", 3, "Maximum number of activation emails resent to an address per window")
+	flag.DurationVar(&cfg.activation.resendWindow, "activation-resend-window", time.Hour, "Window of the activation email resend limit")
 
 	// Read the SMTP server configuration settings into the config struct, using the
 	// Mailtrap settings as the default values.
@@ -61,12 +179,79 @@ func main() {
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Hello Nerds <no-reply@hello.nerds.net>", "SMTP sender")
 
 	flag.Parse()
+
+	// Wrong two-factor codes only count towards the lockout, there are too few
+	// attempts before it for delays to matter.
+	cfg.login.twoFactor.FreeAttempts = cfg.login.twoFactor.Threshold
+	cfg.login.twoFactor.Window = time.Hour
+
 	cfg.es.Addresses = strings.Split(clusterURLs, ",")
 	
 	// Initialize a new jsonlog.Logger which writes any messages *at or above* the INFO
 	// severity level to the standard out stream
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	// The synchronizer keeps draining without a pause as long as it gets full batches,
+	// with an empty batch size it would never pause at all.
+	if cfg.sync.batchSize < 1 {
+		logger.PrintFatal(fmt.Errorf("invalid sync batch size %d, must be at least 1", cfg.sync.batchSize), nil)
+	}
+
+	if cfg.rebuild.batchSize < 1 {
+		logger.PrintFatal(fmt.Errorf("invalid rebuild batch size %d, must be at least 1", cfg.rebuild.batchSize), nil)
+	}
+
+	for _, policy := range []data.LockoutPolicy{cfg.login.account, cfg.login.ip, cfg.login.twoFactor} {
+		if policy.FreeAttempts < 0 {
+			logger.PrintFatal(fmt.Errorf("invalid login free attempts %d, must not be negative", policy.FreeAttempts), nil)
+		}
+		if policy.BaseDelay < 0 || policy.MaxDelay < policy.BaseDelay {
+			logger.PrintFatal(fmt.Errorf("invalid login delays %s to %s, must be between 0 and the max delay", policy.BaseDelay, policy.MaxDelay), nil)
+		}
+		if policy.Window <= 0 {
+			logger.PrintFatal(fmt.Errorf("invalid login window %s, must be positive", policy.Window), nil)
+		}
+		// A threshold below 1 would lock out on every attempt, for good.
+		if policy.Threshold < 1 {
+			logger.PrintFatal(fmt.Errorf("invalid login lockout threshold %d, must be at least 1", policy.Threshold), nil)
+		}
+		if policy.Lockout <= 0 {
+			logger.PrintFatal(fmt.Errorf("invalid login lockout duration %s, must be positive", policy.Lockout), nil)
+		}
+	}
+
+	for _, width := range strings.Split(coverWidths, ",") {
+		w, err := strconv.Atoi(strings.TrimSpace(width))
+		if err != nil || w < 1 {
+			logger.PrintFatal(fmt.Errorf("invalid cover width %q", width), nil)
+		}
+		cfg.covers.widths = append(cfg.covers.widths, w)
+	}
+
+	// set up the signer for the stateless access tokens
+	var signer *jwt.Signer
+	switch cfg.auth.mode {
+	case authModeOpaque:
+	case authModeJWT:
+		keys, err := jwt.ParseKeys(cfg.auth.jwtKeys)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		signer, err = jwt.NewSigner(keys, cfg.auth.jwtKeyID)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid auth mode %q", cfg.auth.mode), nil)
+	}
+
+	// set up the OpenID Connect providers
+	oidcProviders, err := oidc.ParseProviders(cfg.oidc.providers, cfg.oidc.redirectURL, &http.Client{Timeout: 10 * time.Second})
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	// create connection pool
 	db, err := openDB(cfg)
 	if err != nil {
@@ -86,12 +271,38 @@ func main() {
 		logger.PrintFatal(err, nil)
 	}
 
+	// set up the cover proxy
+	coverCache, err := covers.NewDiskCache(cfg.covers.cacheDir, cfg.covers.cacheMaxBytes)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	// inject all dependencies
 	app := &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModel(db, es),
+		models: data.NewModel(db, es, cfg.esIndex),
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		covers: &covers.Service{
+			Origin: covers.NewHTTPOrigin(cfg.covers.origin, 10*time.Second),
+			Cache:  coverCache,
+			Widths: cfg.covers.widths,
+		},
+		activationLimiter: newKeyedLimiter(cfg.activation.resendLimit, cfg.activation.resendWindow),
+		clickLimiter: newKeyedLimiter(60, time.Minute),
+		jwt: signer,
+		revoked: newRevocationList(),
+		oidc: oidcProviders,
+		// The analysis of the previous index is gone once it's replaced, so there's
+		// nothing to roll back to and it's deleted.
+		reindexer: &indexer.Reindexer{
+			Books:     data.BookModel{DB: db, ES: es, Index: cfg.esIndex},
+			Outbox:    data.OutboxModel{DB: db},
+			Logger:    logger,
+			BatchSize: cfg.rebuild.batchSize,
+			MaxDrift:  cfg.rebuild.maxDrift,
+			DeleteOld: true,
+		},
 	}
 
 	// Call app.serve() to start the server
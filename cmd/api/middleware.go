@@ -7,7 +7,6 @@ import (
 	"strings"
 
 	"github.com/hafizmfadli/hello-nerds-api/internal/data"
-	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -69,19 +68,10 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Extract the actual authentication token from the header parts
 		token := headerParts[1]
 
-		// Validate the token to make sure it is in a sensible format
-		v := validator.New()
-
-		// If the token isn't valikd, use the invalidAuthenticationTokenResponse()
-		// helper to send a response, rather than failedValidationResponse() helper
-		// that we'd normally use.
-		if data.ValidateTokenPlainText(v, token); !v.Valid() {
-			app.invalidAuthenticationTokenResponse(w, r)
-			return
-		}
-
 		// Retrieve the details of the user associated with the authentication token.
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		// If the token isn't valid, use the invalidAuthenticationTokenResponse() helper
+		// to send a response.
+		user, claims, err := app.authenticateToken(r, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -95,6 +85,10 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Call the contextSetUser() helper to add the user information to the request
 		// context
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
+		if claims != nil {
+			r = app.contextSetClaims(r, claims)
+		}
 
 		next.ServeHTTP(w, r)
 	})
@@ -133,11 +127,8 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		// Retrieve the user from the request context.
-		user := app.contextGetUser(r)
-
-		// Get the slice of permissions for the user.
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		// Get the slice of permissions for the user in the request context.
+		permissions, err := app.userPermissions(r)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -150,8 +141,9 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		}
 
 		// Otherwise they have the required permission so we call the next handler in
-		// the chain
-		next.ServeHTTP(w, r)
+		// the chain, with the permissions in the request context so checks further down
+		// (like app.hasPermission()) don't look them up again.
+		next.ServeHTTP(w, app.contextSetPermissions(r, permissions))
 	}
 
 	// Wrap this with the requireActivatedUser() middleware before returning it.
@@ -163,7 +155,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		// for develepment purpose we allow all header for a while.
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Headers", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE, HEAD, OPTIONS, POST, PUT")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE, HEAD, OPTIONS, PATCH, POST, PUT")
 		next.ServeHTTP(w, r)
 	})
 }
\ No newline at end of file
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/oidc"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// oidcLoginTTL is how long a user has to log in at the provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// Start a login with an OpenID Connect provider. The client keeps the returned state
+// verifier to itself and sends the user to the returned authorization URL; the
+// provider sends them back to the redirect URL with a code and the state, which the
+// client then exchanges for tokens with createOIDCTokenHandler, along with the state
+// verifier.
+func (app *application) createOIDCAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidc[httprouter.ParamsFromContext(r.Context()).ByName("provider")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// The state ties the code to this login, the nonce ties the ID token to it, and
+	// the PKCE verifier proves to the provider that the code is exchanged by whoever
+	// started the login. The state verifier proves the same to us: the state travels
+	// through the user's browser, so without it anyone could start a login, stop at
+	// the redirect and get someone else's client to finish it, logging them in to the
+	// wrong account.
+	var login data.OIDCLogin
+	var state, stateVerifier string
+	var err error
+
+	for _, s := range []*string{&state, &stateVerifier, &login.Nonce, &login.Verifier} {
+		*s, err = oidc.Random()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	login.Provider = provider.Name
+
+	authorizationURL, err := provider.AuthCodeURL(r.Context(), state, login.Nonce, login.Verifier)
+	if err != nil {
+		app.badGatewayResponse(w, r, err)
+		return
+	}
+
+	err = app.models.OIDCLogins.Insert(state, stateVerifier, &login, oidcLoginTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization_url": authorizationURL, "state": state, "state_verifier": stateVerifier}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Finish a login with an OpenID Connect provider: exchange the code for an ID token,
+// find or create the user the identity belongs to, and log them in like
+// createAuthenticationTokenHandler does.
+func (app *application) createOIDCTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code          string `json:"code"`
+		State         string `json:"state"`
+		StateVerifier string `json:"state_verifier"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(input.State != "", "state", "must be provided")
+	v.Check(input.StateVerifier != "", "state_verifier", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	login, err := app.models.OIDCLogins.Take(input.State, input.StateVerifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired login state")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The provider may have been removed from the configuration in the meantime.
+	provider, ok := app.oidc[login.Provider]
+	if !ok {
+		v.AddError("state", "invalid or expired login state")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), input.Code, login.Verifier, login.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidCode), errors.Is(err, oidc.ErrInvalidToken):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.badGatewayResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Identities.GetUser(provider.Name, claims.Subject)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if _, ok := linkableEmail(claims); !ok {
+			v.AddError("email", "must be verified by the provider")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		user, err = app.linkIdentity(provider.Name, claims)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.models.Identities.Touch(provider.Name, claims.Subject)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// linkableEmail returns the email a new identity is linked to a user by. That's only
+// possible when the provider verified the email: anyone can put someone else's address
+// on their account at some providers.
+func linkableEmail(claims *oidc.Claims) (string, bool) {
+	if claims.Email == "" || !bool(claims.EmailVerified) {
+		return "", false
+	}
+	return claims.Email, true
+}
+
+// linkIdentity links a new identity to the user with the verified email of the
+// identity, creating the user if there's none.
+func (app *application) linkIdentity(provider string, claims *oidc.Claims) (*data.User, error) {
+	user, err := app.models.Users.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// Someone may have registered the address without owning it, hoping to get
+		// into the account once the owner logs in here. The provider proved the owner
+		// is logging in now, so the account is activated and the password and
+		// sessions of whoever registered it are thrown away.
+		if !user.Activated {
+			err = app.claimUser(user)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, data.ErrRecordNotFound):
+		user, err = app.insertOIDCUser(claims)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	err = app.models.Identities.Link(user.ID, provider, claims.Subject, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// claimUser activates an unactivated user on behalf of the verified owner of its email.
+func (app *application) claimUser(user *data.User) error {
+	password, err := oidc.Random()
+	if err != nil {
+		return err
+	}
+
+	err = user.Password.Set(password)
+	if err != nil {
+		return err
+	}
+
+	user.Activated = true
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		return err
+	}
+
+	for _, scope := range []string{data.ScopeActivation, data.ScopeAuthentication, data.ScopeRefresh, data.ScopePasswordReset} {
+		err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.revokeSignedTokens(user.ID)
+}
+
+// insertOIDCUser creates an activated user from the claims of an ID token. The user
+// gets a random password nobody knows; they can set one with a password reset.
+func (app *application) insertOIDCUser(claims *oidc.Claims) (*data.User, error) {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" {
+		fields := strings.Fields(claims.Name)
+		if len(fields) > 0 {
+			firstName = fields[0]
+			if lastName == "" {
+				lastName = strings.Join(fields[1:], " ")
+			}
+		}
+	}
+	if firstName == "" {
+		firstName = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+
+	user := &data.User{
+		FirstName: truncateName(firstName),
+		LastName:  truncateName(lastName),
+		Email:     claims.Email,
+	}
+
+	password, err := oidc.Random()
+	if err != nil {
+		return nil, err
+	}
+
+	err = user.Password.Set(password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	// The provider verified the email, so there's no activation email to send.
+	user.Activated = true
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		return nil, err
+	}
+
+	// New users are customers, like the ones who register, see registerUserHandler.
+	err = app.models.Roles.AddForUser(user.ID, data.RoleCustomer)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// truncateName cuts a name from a provider down to the length of the name fields, see
+// data.ValidateUser.
+func truncateName(name string) string {
+	const max = 30
+
+	if len(name) <= max {
+		return name
+	}
+
+	n := max
+	for n > 0 && !utf8.RuneStart(name[n]) {
+		n--
+	}
+	return name[:n]
+}
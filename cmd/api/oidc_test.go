@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/oidc"
+)
+
+func TestLinkableEmail(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims string
+		want   string
+		wantOK bool
+	}{
+		{"verified", `{"email": "user@example.com", "email_verified": true}`, "user@example.com", true},
+		{"verified as a string", `{"email": "user@example.com", "email_verified": "true"}`, "user@example.com", true},
+		{"unverified", `{"email": "user@example.com", "email_verified": false}`, "", false},
+		{"unverified as a string", `{"email": "user@example.com", "email_verified": "false"}`, "", false},
+		{"verification unknown", `{"email": "user@example.com"}`, "", false},
+		{"verification null", `{"email": "user@example.com", "email_verified": null}`, "", false},
+		{"no email", `{"email_verified": true}`, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims oidc.Claims
+			if err := json.Unmarshal([]byte(tt.claims), &claims); err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := linkableEmail(&claims)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("linkableEmail(%s) = %q, %v, want %q, %v", tt.claims, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
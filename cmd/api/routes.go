@@ -27,13 +27,65 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/books", app.listBooksHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/books/suggest", app.listBookSuggestionsHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/books/detail/:id", app.showBookHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/books/detail/:id/cover", app.showBookCoverHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/books/detail/:id/related", app.listRelatedBooksHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/books/detail/:id/reviews", app.listBookReviewsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/books/detail/:id/reviews", app.requireActivatedUser(app.createReviewHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/search/clicks", app.createSearchClickHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/analytics/search/top-queries", app.requirePermission("analytics:read", app.searchReportHandler("top-queries")))
+	router.HandlerFunc(http.MethodGet, "/v1/analytics/search/zero-results", app.requirePermission("analytics:read", app.searchReportHandler("zero-results")))
+	router.HandlerFunc(http.MethodGet, "/v1/analytics/search/click-through", app.requirePermission("analytics:read", app.searchReportHandler("click-through")))
+
+	router.HandlerFunc(http.MethodGet, "/v1/search/synonyms", app.requirePermission("search:write", app.listSynonymsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/search/synonyms", app.requirePermission("search:write", app.createSynonymHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/search/synonyms/:id", app.requirePermission("search:write", app.deleteSynonymHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/search/stopwords", app.requirePermission("search:write", app.listStopwordsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/search/stopwords", app.requirePermission("search:write", app.createStopwordHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/search/stopwords/:id", app.requirePermission("search:write", app.deleteStopwordHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/search/analysis", app.requirePermission("search:write", app.applySearchAnalysisHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/search/analyze", app.requirePermission("search:write", app.analyzeHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/authors", app.listAuthorsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/authors/:slug", app.showAuthorHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/publishers/:slug", app.showPublisherHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/collections", app.listCollectionsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:slug/books", app.listCollectionBooksHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/collections", app.requirePermission("books:write", app.createCollectionHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:slug", app.requirePermission("books:write", app.updateCollectionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:slug", app.requirePermission("books:write", app.deleteCollectionHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/collections/:slug/books", app.requirePermission("books:write", app.setCollectionBooksHandler))
+
+	router.HandlerFunc(http.MethodPatch, "/v1/reviews/:id", app.requireActivatedUser(app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/reviews/:id", app.requireActivatedUser(app.deleteReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/reviews/:id/moderation", app.requirePermission("reviews:moderate", app.moderateReviewHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/books", app.requirePermission("books:write", app.createBookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/books/:id", app.requirePermission("books:write", app.updateBookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/books/:id", app.requirePermission("books:write", app.deleteBookHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/catalog/import", app.requirePermission("books:write", app.importCatalogHandler))
 
 	// router.HandlerFunc(http.MethodGet, "/v1/books/detail/:id", app.requirePermission("books:read", app.showBookHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/me", app.requireAuthenticatedUser(app.showMeHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/me", app.requireAuthenticatedUser(app.updateMeHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/me/password", app.requireAuthenticatedUser(app.updateMyPasswordHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/me/email", app.requireAuthenticatedUser(app.createEmailChangeHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/me/two-factor", app.requireAuthenticatedUser(app.enrollTwoFactorHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/me/two-factor/confirm", app.requireAuthenticatedUser(app.confirmTwoFactorHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/me/two-factor", app.requireAuthenticatedUser(app.disableTwoFactorHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/me/sessions", app.requireAuthenticatedUser(app.listMySessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/me/sessions", app.requireAuthenticatedUser(app.deleteMySessionsHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/two-factor", app.createTwoFactorTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
 
 	router.HandlerFunc(http.MethodGet, "/v1/provinces", app.listProvincesHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/cities", app.listCitiesHandler)
@@ -48,7 +100,15 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodPost, "/v1/checkout", app.checkoutHandler)
 	router.HandlerFunc(http.MethodDelete, "/v1/logout", app.requireAuthenticatedUser(app.removeAuthenticationTokenHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/users/updateBookStock", app.updateBookStockHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/oidc/:provider/authorization", app.createOIDCAuthorizationHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/oidc", app.createOIDCTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/lockout", app.requirePermission("users:write", app.unlockUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/roles", app.requirePermission("users:write", app.showUserRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/roles", app.requirePermission("users:write", app.addUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/roles/:role", app.requirePermission("users:write", app.removeUserRoleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/permissions", app.requirePermission("users:write", app.addUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/permissions/:code", app.requirePermission("users:write", app.removeUserPermissionHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/updateBookStock", app.requirePermission("books:write", app.updateBookStockHandler))
 
 	return app.recoverPanic(app.enableCORS(app.authenticate(router)))
 }
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+)
+
+func (app *application) listSynonymsHandler(w http.ResponseWriter, r *http.Request) {
+	synonyms, err := app.models.SearchTerms.GetSynonyms()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"synonyms": synonyms}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createSynonymHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Rule string `json:"rule"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	synonym := &data.Synonym{Rule: input.Rule}
+
+	v := validator.New()
+
+	if data.ValidateSynonym(v, synonym); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.SearchTerms.InsertSynonym(synonym)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"synonym": synonym}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSynonymHandler(w http.ResponseWriter, r *http.Request) {
+	app.deleteSearchTerm(w, r, app.models.SearchTerms.DeleteSynonym, "synonym successfully deleted")
+}
+
+func (app *application) listStopwordsHandler(w http.ResponseWriter, r *http.Request) {
+	stopwords, err := app.models.SearchTerms.GetStopwords()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stopwords": stopwords}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createStopwordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Word string `json:"word"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	stopword := &data.Stopword{Word: input.Word}
+
+	v := validator.New()
+
+	if data.ValidateStopword(v, stopword); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.SearchTerms.InsertStopword(stopword)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateStopword):
+			v.AddError("word", "is already a stopword")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"stopword": stopword}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteStopwordHandler(w http.ResponseWriter, r *http.Request) {
+	app.deleteSearchTerm(w, r, app.models.SearchTerms.DeleteStopword, "stopword successfully deleted")
+}
+
+func (app *application) deleteSearchTerm(w http.ResponseWriter, r *http.Request, del func(int64) error, message string) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = del(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// applySearchAnalysisHandler pushes the current synonyms and stopwords to the search
+// index. Analysis settings can't be changed on an open index, so the index is rebuilt
+// with them in the background like cmd/reindex does, and the alias is swapped over
+// once it's loaded. Searches keep using the live index in the meantime. A rebuild cut
+// short by a shutdown is marked as failed and can be applied again.
+func (app *application) applySearchAnalysisHandler(w http.ResponseWriter, r *http.Request) {
+	analysis, err := app.models.SearchTerms.Analysis()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only one rebuild at a time: the one started by this instance, or by anyone
+	// else (another instance or cmd/reindex).
+	if !atomic.CompareAndSwapInt32(&app.rebuilding, 0, 1) {
+		app.rebuildRunningResponse(w, r)
+		return
+	}
+
+	builds, err := app.models.Outbox.Builds()
+	if err != nil {
+		atomic.StoreInt32(&app.rebuilding, 0)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if len(builds) > 0 {
+		atomic.StoreInt32(&app.rebuilding, 0)
+		app.rebuildRunningResponse(w, r)
+		return
+	}
+
+	reindexer := *app.reindexer
+	reindexer.Analysis = analysis
+
+	app.background(func() {
+		defer atomic.StoreInt32(&app.rebuilding, 0)
+
+		// Shutting down cancels the rebuild, rather than waiting for the whole
+		// catalog to be loaded.
+		index, err := reindexer.Run(app.workerCtx)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		app.logger.PrintInfo("search analysis applied", map[string]string{"index": index})
+	})
+
+	env := envelope{
+		"message":   "the search index is being rebuilt with the search analysis",
+		"synonyms":  len(analysis.Synonyms),
+		"stopwords": len(analysis.Stopwords),
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// analyzeHandler shows the tokens a piece of text is turned into, by the search
+// analyzer (the default) or by the index analyzer (analyzer=index).
+func (app *application) analyzeHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	text := app.readString(qs, "text", "")
+	analyzer := app.readString(qs, "analyzer", "search")
+
+	v.Check(text != "", "text", "must be provided")
+	v.Check(len(text) <= 1000, "text", "must not be more than 1000 bytes long")
+	v.Check(validator.In(analyzer, "search", "index"), "analyzer", "must be search or index")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tokens, err := app.models.Books.Analyze(text, analyzer == "index")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"analyzer": analyzer, "tokens": tokens}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
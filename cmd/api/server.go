@@ -10,6 +10,8 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/indexer"
 )
 
 func (app *application) serve() error {
@@ -25,6 +27,40 @@ func (app *application) serve() error {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	// Create a context for the long running background workers, it's cancelled when
+	// the server starts shutting down.
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	app.workerCtx = workerCtx
+
+	// Start the synchronizer which keeps the search index up to date with the catalog.
+	if app.config.sync.enabled {
+		syncer := &indexer.Syncer{
+			Models:       app.models,
+			Logger:       app.logger,
+			BatchSize:    app.config.sync.batchSize,
+			PollInterval: app.config.sync.interval,
+		}
+
+		app.background(func() {
+			syncer.Run(workerCtx)
+		})
+	}
+
+	// Keep the "customers also bought" counts up to date with the orders.
+	if app.config.alsoBought.refreshInterval > 0 {
+		app.background(func() {
+			app.refreshAlsoBought(workerCtx)
+		})
+	}
+
+	// In the jwt token mode, keep the in-memory revocation list up to date.
+	if app.jwt != nil {
+		app.background(func() {
+			app.syncRevocations(workerCtx)
+		})
+	}
+
 	// Create a shutdownError channel. We will use this to receive any errors returned
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
@@ -64,6 +100,9 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 
+		// Tell the background workers to stop after their current batch.
+		stopWorkers()
+
 		// Log a message to say that we're waiting for any background goroutines to
 		// complete their tasks.
 		app.logger.PrintInfo("completing background tasks", map[string]string{
@@ -3,6 +3,8 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hafizmfadli/hello-nerds-api/internal/data"
@@ -33,10 +35,131 @@ func (app *application) createAuthenticationTokenHandler (w http.ResponseWriter,
 		return
 	}
 
+	// Refuse to even check the password while the account or the client's IP address
+	// has to wait because of earlier failed logins. Otherwise the attempt counts as a
+	// failure until the password turns out to be right.
+	wait, locked, err := app.reserveLogin(r, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if wait > 0 {
+		app.rateLimitExceededResponse(w, r, wait)
+		return
+	}
+
 	// Lookup the user record based on the email address. If no matching user was
-	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
-	// Unauthorized response to the client
+	// found, we still compare the password against a dummy hash, so the response
+	// takes just as long as for a wrong password.
 	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	match := false
+	if user != nil {
+		// Check if the provided password matches the actual password for the user
+		match, err = user.Password.Matches(input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		data.MatchesDummyPassword(input.Password)
+	}
+
+	// If the passwords don't match, the failure is already recorded, we only have to
+	// call the app.invalidCredentialsResponse()
+	if !match {
+		if locked {
+			app.notifyLoginLockout(r, user)
+		}
+
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.releaseLogin(r, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// completeLogin logs in a user whose first factor (their password, or an OpenID
+// Connect provider) checked out. Users with two-factor authentication get a
+// short-lived challenge token instead, which they exchange for the real tokens together
+// with a code from their authenticator app, see createTwoFactorTokenHandler.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	if user.TwoFactor {
+		challenge, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeTwoFactor)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"two_factor_required": true, "challenge_token": challenge}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeSession(w, r, user)
+}
+
+// writeSession logs the user in: we generate a short-lived access token (scope
+// `authentication`) and a refresh token to get new ones with, and send them in a 201
+// Created response. The client they were issued to is recorded for the session list.
+func (app *application) writeSession(w http.ResponseWriter, r *http.Request, user *data.User) {
+	token, refreshToken, err := app.newSession(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"authentication_token": token, "refresh_token": refreshToken, "user_info": user}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Exchange a two-factor challenge token and a TOTP code (or a recovery code) for an
+// access token and refresh token. Wrong codes are recorded per user like failed logins,
+// six digit codes are easy to guess otherwise: once they lock the user's two-factor
+// logins, the challenge tokens are deleted, so the password has to be entered again.
+func (app *application) createTwoFactorTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.ChallengeToken != "", "challenge_token", "must be provided")
+	v.Check(len(input.ChallengeToken) == 26, "challenge_token", "must be 26 bytes long")
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "must be provided")
+	v.Check(input.Code == "" || input.RecoveryCode == "", "recovery_code", "must not be provided together with code")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeTwoFactor, input.ChallengeToken)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -47,39 +170,80 @@ func (app *application) createAuthenticationTokenHandler (w http.ResponseWriter,
 		return
 	}
 
-	// Check if the provided password matches the actual password for the user
-	match, err := user.Password.Matches(input.Password)
+	subject := strconv.FormatInt(user.ID, 10)
+
+	wait, locked, err := app.models.LoginFailures.Reserve(data.LoginFailureTwoFactor, subject, app.config.login.twoFactor)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
-	if !match {
+	if wait > 0 {
+		app.rateLimitExceededResponse(w, r, wait)
+		return
+	}
+
+	var valid bool
+	if input.Code != "" {
+		valid, err = app.models.TwoFactor.VerifyCode(user.ID, input.Code)
+	} else {
+		valid, err = app.models.TwoFactor.UseRecoveryCode(user.ID, input.RecoveryCode)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !valid {
+		if locked {
+			err = app.models.Tokens.DeleteAllForUser(data.ScopeTwoFactor, user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope `authentication`.
-	token, err := app.models.Tokens.New(user.ID, 24 * time.Hour, data.ScopeAuthentication)
+	err = app.models.LoginFailures.Reset(data.LoginFailureTwoFactor, subject)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Encode the token to JSON and send it in the response along with a 201 Created
-	// status code
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "user_info": user}, nil)
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeTwoFactor, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
+
+	app.writeSession(w, r, user)
 }
 
+// Log out by revoking the authentication token the request was made with, along with
+// its refresh token. Other sessions of the user stay logged in, see
+// deleteMySessionsHandler for those.
 func (app *application) removeAuthenticationTokenHandler (w http.ResponseWriter, r *http.Request){
-	// Parse the email and password from the request body
+	err := app.revokeRequestToken(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "logout success"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Exchange a refresh token for a new access token and refresh token. The old refresh
+// token can't be used again: if it is, the whole session is revoked, along with the
+// user's signed access tokens in jwt mode.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		UserID    int64 `json:"user_id"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -88,14 +252,159 @@ func (app *application) removeAuthenticationTokenHandler (w http.ResponseWriter,
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, input.UserID)
+	v := validator.New()
+
+	if data.ValidateTokenPlainText(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, refreshToken, err := app.refreshSession(r, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		case errors.Is(err, data.ErrTokenReused):
+			app.logger.PrintInfo("refresh token reused, session and signed tokens revoked", map[string]string{"ip": clientIP(r)})
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Generate a password reset token and send it to the user's email address. The
+// response is the same whether or not a user with that email exists, so this endpoint
+// can't be used to find out who has an account.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "logout success"}, nil)
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Otherwise, create a new password reset token with a 45-minute expiry time.
+	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
-}
\ No newline at end of file
+
+	// Email the user with their password reset token.
+	app.background(func() {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Send a fresh activation token to an unactivated account, replacing the old ones. Like
+// the password reset endpoint, the response doesn't tell whether the email belongs to
+// an account or whether it is activated already.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The limit applies to every address, registered or not, so hitting it gives
+	// nothing away either.
+	allowed, retryAfter := app.activationLimiter.allow(strings.ToLower(input.Email))
+	if !allowed {
+		app.rateLimitExceededResponse(w, r, retryAfter)
+		return
+	}
+
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err == nil && !user.Activated {
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"activationToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/totp"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+)
+
+// totpIssuer is the account issuer shown in authenticator apps.
+const totpIssuer = "Hello Nerds"
+
+// Start enrolling in two-factor authentication. The response contains the secret and
+// the otpauth:// URI to show as a QR code; two-factor authentication is only enabled
+// once a code generated from it is confirmed.
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.loadUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Password != "", "password", "must be provided")
+	v.Check(!user.TwoFactor, "two_factor", "is already enabled")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.checkCurrentPassword(w, r, user, input.Password, "password", v) {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TwoFactor.Enroll(user.ID, secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"secret": secret, "otpauth_uri": totp.URI(totpIssuer, user.Email, secret)}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Confirm the enrollment with a code from the authenticator app, which enables
+// two-factor authentication and returns a fresh set of recovery codes. The codes are
+// only ever shown here. Sessions started before, which only needed the password, are
+// logged out, except the one making this request.
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recoveryCodes, err := data.GenerateRecoveryCodes(data.RecoveryCodeCount)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ok, err := app.models.TwoFactor.Enable(app.contextGetUser(r).ID, input.Code, recoveryCodes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorNotEnrolled):
+			v.AddError("code", "no two-factor enrollment in progress")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTwoFactorEnabled):
+			v.AddError("two_factor", "is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !ok {
+		v.AddError("code", "is invalid or expired")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.revokeOtherSessions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "two-factor authentication enabled", "recovery_codes": recoveryCodes}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Turn off two-factor authentication. Both the password and a current code (or a
+// recovery code) are required.
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.loadUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Password != "", "password", "must be provided")
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "must be provided")
+	v.Check(user.TwoFactor, "two_factor", "is not enabled")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.checkCurrentPassword(w, r, user, input.Password, "password", v) {
+		return
+	}
+
+	var valid bool
+	if input.Code != "" {
+		valid, err = app.models.TwoFactor.VerifyCode(user.ID, input.Code)
+	} else {
+		valid, err = app.models.TwoFactor.UseRecoveryCode(user.ID, input.RecoveryCode)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !valid {
+		v.AddError("code", "is invalid or expired")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.TwoFactor.Disable(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -70,10 +70,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Add the "books:read" permission for the new user.
-	// (This is just for testing purpose, In the future we wanna give user permission
-	// related to cart).
-	err = app.models.Permissions.AddForUser(user.ID, "books:read")
+	// New users are customers, which gives them the permissions of the customer role.
+	err = app.models.Roles.AddForUser(user.ID, data.RoleCustomer)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -179,6 +177,96 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// Set a new password for the user who owns the password reset token. All of the user's
+// authentication tokens are revoked afterwards, so anyone who got hold of the old
+// password is logged out.
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password        string `json:"password"`
+		ConfirmPassword string `json:"confirm_password"`
+		TokenPlaintext  string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateConfirmPassword(v, input.Password, input.ConfirmPassword)
+	data.ValidateTokenPlainText(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Retrieve the details of the user associated with the password reset token,
+	// returning an error message if no matching record was found.
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConlictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The reset token is single use, and existing sessions must not outlive the old
+	// password.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeRefresh, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.revokeSignedTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) checkoutHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Token                     string                      `json:"token"`
@@ -211,12 +299,12 @@ func (app *application) checkoutHandler(w http.ResponseWriter, r *http.Request)
 	var userID interface{}
 	if input.CheckoutType == data.MemberCheckout {
 		// Validate token only when is member checkout
-		if data.ValidateTokenPlainText(v, input.Token); !v.Valid() {
+		if v.Check(input.Token != "", "token", "must be provided"); !v.Valid() {
 			app.failedValidationResponse(w, r, v.Errors)
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, input.Token)
+		user, _, err := app.authenticateToken(r, input.Token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/hafizmfadli/hello-nerds-api/internal/catalog"
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/jsonlog"
+)
+
+// catalog-import loads books from a CSV or JSON Lines file into the catalog. Every row
+// is validated and upserted into updated_edited by its identifier. The search index
+// catches up through the books outbox, like for any other change to the catalog. The
+// import report is written to stdout as JSON.
+//
+// Usage:
+//
+//	go run ./cmd/catalog-import -file books.csv [-format csv|jsonl] [-dry-run]
+func main() {
+	var (
+		dsn    string
+		file   string
+		format string
+		dryRun bool
+	)
+
+	flag.StringVar(&dsn, "db-dsn", os.Getenv("HELLO_NERDS_DB_DSN"), "MySQL DSN")
+	flag.StringVar(&file, "file", "-", "File to import, - reads from stdin")
+	flag.StringVar(&format, "format", "", "Input format (csv|jsonl), guessed from the file extension when empty")
+	flag.BoolVar(&dryRun, "dry-run", false, "Validate the input and report what would change without writing anything")
+
+	flag.Parse()
+
+	// Log to stderr so stdout only carries the report.
+	logger := jsonlog.New(os.Stderr, jsonlog.LevelInfo)
+
+	if format == "" {
+		format = catalog.FormatFromName(filepath.Ext(file))
+	}
+
+	var in io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	reader, err := catalog.NewReader(format, in)
+	if err != nil {
+		logger.PrintFatal(err, map[string]string{"file": file, "format": format})
+	}
+
+	db, err := openDB(dsn)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer db.Close()
+
+	// The importer only needs MySQL, so the models get no Elasticsearch client.
+	importer := catalog.Importer{
+		Models: data.NewModel(db, nil, ""),
+		DryRun: dryRun,
+	}
+
+	logger.PrintInfo("starting import", map[string]string{"file": file, "format": format})
+
+	report, importErr := importer.Import(reader)
+
+	// Print the report even when the import was aborted, so it's clear which rows
+	// made it in.
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(report); err != nil {
+		logger.PrintError(err, nil)
+	}
+
+	if importErr != nil {
+		logger.PrintFatal(importErr, nil)
+	}
+
+	logger.PrintInfo("import finished", nil)
+}
+
+// The openDB() function returns a sql.DB connection pool
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
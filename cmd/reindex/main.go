@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	elasticsearch "github.com/elastic/go-elasticsearch/v7"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/indexer"
+	"github.com/hafizmfadli/hello-nerds-api/internal/jsonlog"
+)
+
+// reindex rebuilds the books search index from the updated_edited table in MySQL
+// without downtime. It creates a new versioned index (e.g. books-v1-20220601120000)
+// with the mappings from internal/data, loads it, checks the document count against
+// the catalog and then atomically points the -es-index alias at it.
+//
+// The first run also migrates a plain "books-v1" index: it's replaced by an alias with
+// the same name, so the API doesn't need any configuration change.
+//
+// Changes made to the catalog while the rebuild is running are synced by the API into
+// both the old and the new index, so the new index doesn't miss them. Books added or
+// deleted during the rebuild make the document counts differ a little, which -max-drift
+// allows for.
+//
+// The synonyms and stopwords managed through the API are rendered into the search
+// analyzer of the new index.
+//
+// When a release only adds new fields to the mappings, -mapping-only adds them to the
+// live index instead of rebuilding it. The new fields are filled in as books change.
+// It leaves the analysis settings alone, since those can only be changed by closing
+// the index: indices created before the search analyzer existed need a full rebuild.
+//
+// Usage:
+//
+//	go run ./cmd/reindex [-es-index books-v1] [-batch-size 1000] [-delete-old] [-mapping-only]
+func main() {
+	var (
+		dsn         string
+		clusterURLs string
+		esIndex     string
+		mappingOnly bool
+	)
+
+	var reindexer indexer.Reindexer
+
+	flag.StringVar(&dsn, "db-dsn", os.Getenv("HELLO_NERDS_DB_DSN"), "MySQL DSN")
+	flag.StringVar(&clusterURLs, "es-cluster-URLs", "http://127.0.0.1:9200", "Elasticsearch Cluster URLs")
+	flag.StringVar(&esIndex, "es-index", "books-v1", "Elasticsearch books index alias")
+	flag.IntVar(&reindexer.BatchSize, "batch-size", 1000, "Number of books per Elasticsearch bulk request")
+	flag.IntVar(&reindexer.MaxDrift, "max-drift", indexer.DefaultMaxDrift, "Allowed difference between the catalog, the loaded books and the new index document count")
+	flag.BoolVar(&reindexer.DeleteOld, "delete-old", false, "Delete the previous index after swapping the alias")
+	flag.BoolVar(&mappingOnly, "mapping-only", false, "Only add new fields to the mappings of the live index")
+
+	flag.Parse()
+
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	db, err := openDB(dsn)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer db.Close()
+
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: strings.Split(clusterURLs, ",")})
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	reindexer.Books = data.BookModel{DB: db, ES: es, Index: esIndex}
+	reindexer.Outbox = data.OutboxModel{DB: db}
+	reindexer.Logger = logger
+
+	reindexer.Analysis, err = data.SearchTermModel{DB: db}.Analysis()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if mappingOnly {
+		err = reindexer.Books.PutMapping()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		logger.PrintInfo("mappings updated", map[string]string{"index": esIndex})
+		return
+	}
+
+	// Interrupting the rebuild cleans up the new index, instead of leaving it half
+	// loaded and registered as being built.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+
+	index, err := reindexer.Run(ctx)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	logger.PrintInfo("reindex finished", map[string]string{
+		"index":    index,
+		"duration": time.Since(start).String(),
+	})
+}
+
+// The openDB() function returns a sql.DB connection pool
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
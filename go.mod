@@ -8,15 +8,12 @@ require (
 	github.com/julienschmidt/httprouter v1.3.0
 )
 
-require golang.org/x/crypto v0.0.0-20220507011949-2cf3adece122
+require (
+	github.com/go-mail/mail/v2 v2.3.0
+	golang.org/x/crypto v0.0.0-20220507011949-2cf3adece122
+)
 
 require (
-	github.com/go-mail/mail/v2 v2.3.0 // indirect
-	github.com/go-playground/locales v0.14.0 // indirect
-	github.com/go-playground/universal-translator v0.18.0 // indirect
-	github.com/go-playground/validator/v10 v10.11.0 // indirect
-	github.com/leodido/go-urn v1.2.1 // indirect
-	golang.org/x/sys v0.0.0-20210806184541-e5e7981a1069 // indirect
-	golang.org/x/text v0.3.7 // indirect
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+	gopkg.in/mail.v2 v2.3.1 // indirect
 )
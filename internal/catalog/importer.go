@@ -0,0 +1,114 @@
+package catalog
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+)
+
+// RowError describes why a single row was rejected.
+type RowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+// Report summarizes the outcome of an import. In a dry run Inserted and Updated count
+// what would have happened.
+type Report struct {
+	DryRun    bool       `json:"dry_run"`
+	Processed int        `json:"processed"`
+	Inserted  int        `json:"inserted"`
+	Updated   int        `json:"updated"`
+	Failed    int        `json:"failed"`
+	Errors    []RowError `json:"errors,omitempty"`
+}
+
+// Importer validates the rows from a Reader and upserts them by Identifier. It doesn't
+// touch the search index: every upsert is recorded in the books outbox by the
+// triggers on updated_edited, and the search index synchronizer picks it up from
+// there (see internal/indexer).
+type Importer struct {
+	Models data.Models
+	DryRun bool
+}
+
+// Import reads every row from r. Invalid rows are recorded in the report and skipped;
+// only errors that leave us unable to continue (reading the input or talking to MySQL)
+// are returned. The report is returned in both cases, so the caller can
+// tell how far the import got.
+func (im Importer) Import(r Reader) (*Report, error) {
+	report := &Report{DryRun: im.DryRun}
+
+	// seen holds the identifiers a dry run has counted already. A real run inserts
+	// the first row with an identifier and updates the book with the later ones, so
+	// the dry run has to count them the same way. MySQL compares identifiers without
+	// regard to case, so we do too.
+	seen := make(map[string]bool)
+
+	for {
+		row, book, err := r.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			var parseErr *ParseError
+			if errors.As(err, &parseErr) {
+				report.Processed++
+				report.fail(parseErr.Row, map[string]string{"row": parseErr.Err.Error()})
+				continue
+			}
+
+			return report, err
+		}
+
+		report.Processed++
+
+		v := validator.New()
+		if data.ValidateBook(v, book); !v.Valid() {
+			report.fail(row, v.Errors)
+			continue
+		}
+
+		if im.DryRun {
+			identifier := strings.ToLower(*book.Identifier)
+			if seen[identifier] {
+				report.Updated++
+				continue
+			}
+			seen[identifier] = true
+
+			_, err := im.Models.Books.FindIDByIdentifier(*book.Identifier)
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				report.Inserted++
+			case err != nil:
+				return report, err
+			default:
+				report.Updated++
+			}
+			continue
+		}
+
+		inserted, err := im.Models.Books.Upsert(book)
+		if err != nil {
+			return report, err
+		}
+
+		if inserted {
+			report.Inserted++
+		} else {
+			report.Updated++
+		}
+	}
+
+	return report, nil
+}
+
+func (report *Report) fail(row int, errors map[string]string) {
+	report.Failed++
+	report.Errors = append(report.Errors, RowError{Row: row, Errors: errors})
+}
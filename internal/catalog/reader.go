@@ -0,0 +1,229 @@
+package catalog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+)
+
+// Define constants for the supported input formats.
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// ErrUnknownFormat is returned by NewReader for a format we don't know how to read.
+var ErrUnknownFormat = errors.New("unknown format, must be csv or jsonl")
+
+// A ParseError is returned by a Reader when a single row can't be parsed. The rest of
+// the input can still be read, so the importer records it and moves on.
+type ParseError struct {
+	Row int
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Err)
+}
+
+// Reader reads books one row at a time. Read returns the row number (starting at 1 for
+// the first book) along with the book, and io.EOF once the input is exhausted.
+type Reader interface {
+	Read() (int, *data.Book, error)
+}
+
+// record holds a single row. The keys match the JSON keys accepted by the
+// POST /v1/books endpoint, which are also the expected CSV header names.
+type record struct {
+	Title      string `json:"title"`
+	Author     string `json:"author"`
+	CoverUrl   string `json:"coverurl"`
+	Extension  string `json:"extension"`
+	Year       string `json:"year"`
+	Publisher  string `json:"publisher"`
+	Language   string `json:"language"`
+	Identifier string `json:"identifier"`
+	Quantity   int    `json:"stock"`
+	Price      int64  `json:"price"`
+}
+
+func (rec record) book() *data.Book {
+	return &data.Book{
+		Title:      &rec.Title,
+		Author:     &rec.Author,
+		CoverUrl:   &rec.CoverUrl,
+		Extension:  &rec.Extension,
+		Year:       &rec.Year,
+		Publisher:  &rec.Publisher,
+		Language:   &rec.Language,
+		Identifier: &rec.Identifier,
+		Quantity:   rec.Quantity,
+		Price:      rec.Price,
+	}
+}
+
+// NewReader returns a Reader for the given format.
+func NewReader(format string, r io.Reader) (Reader, error) {
+	switch strings.ToLower(format) {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatJSONL, "ndjson":
+		return newJSONLReader(r), nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// FormatFromName guesses the format from a file name or content type, returning an
+// empty string if it can't tell.
+func FormatFromName(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".csv"), strings.Contains(name, "text/csv"):
+		return FormatCSV
+	case strings.HasSuffix(name, ".jsonl"), strings.HasSuffix(name, ".ndjson"),
+		strings.Contains(name, "application/x-ndjson"), strings.Contains(name, "application/jsonl"):
+		return FormatJSONL
+	default:
+		return ""
+	}
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	header map[string]int
+	row    int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	cr.TrimLeadingSpace = true
+
+	// The first line is the header. Columns can come in any order, and unknown ones
+	// are an error so a typo doesn't silently drop a field.
+	columns, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("csv input must start with a header row")
+		}
+		return nil, err
+	}
+
+	known := map[string]bool{
+		"title": true, "author": true, "coverurl": true, "extension": true, "year": true,
+		"publisher": true, "language": true, "identifier": true, "stock": true, "price": true,
+	}
+
+	header := make(map[string]int)
+	for i, column := range columns {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !known[column] {
+			return nil, fmt.Errorf("csv header contains unknown column %q", column)
+		}
+		header[column] = i
+	}
+
+	return &csvReader{r: cr, header: header}, nil
+}
+
+func (c *csvReader) Read() (int, *data.Book, error) {
+	fields, err := c.r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, nil, io.EOF
+		}
+
+		// A malformed record doesn't stop the csv.Reader, so we can carry on with the
+		// next one.
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			c.row++
+			return c.row, nil, &ParseError{Row: c.row, Err: parseErr.Err}
+		}
+		return 0, nil, err
+	}
+
+	c.row++
+
+	get := func(column string) string {
+		i, ok := c.header[column]
+		if !ok || i >= len(fields) {
+			return ""
+		}
+		return strings.TrimSpace(fields[i])
+	}
+
+	rec := record{
+		Title:      get("title"),
+		Author:     get("author"),
+		CoverUrl:   get("coverurl"),
+		Extension:  get("extension"),
+		Year:       get("year"),
+		Publisher:  get("publisher"),
+		Language:   get("language"),
+		Identifier: get("identifier"),
+	}
+
+	if s := get("stock"); s != "" {
+		rec.Quantity, err = strconv.Atoi(s)
+		if err != nil {
+			return c.row, nil, &ParseError{Row: c.row, Err: errors.New("stock must be an integer value")}
+		}
+	}
+
+	if s := get("price"); s != "" {
+		rec.Price, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return c.row, nil, &ParseError{Row: c.row, Err: errors.New("price must be an integer value")}
+		}
+	}
+
+	return c.row, rec.book(), nil
+}
+
+type jsonlReader struct {
+	s   *bufio.Scanner
+	row int
+}
+
+func newJSONLReader(r io.Reader) *jsonlReader {
+	s := bufio.NewScanner(r)
+	// Allow lines up to 1MB, the default 64KB is a bit tight for long descriptions.
+	s.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+	return &jsonlReader{s: s}
+}
+
+func (j *jsonlReader) Read() (int, *data.Book, error) {
+	for j.s.Scan() {
+		line := strings.TrimSpace(j.s.Text())
+		// Skip blank lines, most likely a trailing newline at the end of the file.
+		if line == "" {
+			continue
+		}
+
+		j.row++
+
+		var rec record
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rec); err != nil {
+			return j.row, nil, &ParseError{Row: j.row, Err: err}
+		}
+
+		return j.row, rec.book(), nil
+	}
+
+	if err := j.s.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	return 0, nil, io.EOF
+}
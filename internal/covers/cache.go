@@ -0,0 +1,125 @@
+package covers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DiskCache stores covers as files in Dir. When the total size goes over MaxBytes the
+// least recently used files are removed; reading a file bumps its modification time,
+// so the modification time doubles as the last access time.
+type DiskCache struct {
+	Dir      string
+	MaxBytes int64
+
+	mu sync.Mutex
+}
+
+// NewDiskCache creates the cache directory if needed.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DiskCache{Dir: dir, MaxBytes: maxBytes}, nil
+}
+
+// Get returns the cached data for key along with the time it was stored.
+func (c *DiskCache) Get(key string) ([]byte, time.Time, bool) {
+	path := filepath.Join(c.Dir, key)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+
+	// Mark the file as recently used. The access time is left alone and keeps the
+	// time the file was stored, which we report as the modification time.
+	now := time.Now()
+	_ = os.Chtimes(path, info.ModTime(), now)
+
+	return data, info.ModTime(), true
+}
+
+// Put stores data under key, then trims the cache down to MaxBytes.
+func (c *DiskCache) Put(key string, data []byte) (time.Time, error) {
+	// Write to a temporary file first and rename it into place, so a concurrent Get
+	// never sees a half written file.
+	tmp, err := ioutil.TempFile(c.Dir, ".tmp-*")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return time.Time{}, err
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(tmp.Name(), now, now)
+
+	err = os.Rename(tmp.Name(), filepath.Join(c.Dir, key))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return time.Time{}, err
+	}
+
+	return now, c.evict()
+}
+
+// evict removes the least recently used files until the cache fits in MaxBytes.
+func (c *DiskCache) evict() error {
+	if c.MaxBytes <= 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size()
+	}
+
+	if total <= c.MaxBytes {
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+
+	for _, entry := range entries {
+		if total <= c.MaxBytes {
+			break
+		}
+		if entry.IsDir() {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.Dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= entry.Size()
+	}
+
+	return nil
+}
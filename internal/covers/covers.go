@@ -0,0 +1,104 @@
+// Package covers serves book cover images through our own API: covers are fetched
+// from an origin, scaled down to one of a few allowed widths and cached on disk, so
+// browsers never talk to the origin host directly.
+package covers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Cover is an image ready to be served.
+type Cover struct {
+	Data        []byte
+	ContentType string
+	ModTime     time.Time
+	// ETag changes whenever the cover path or the requested width changes.
+	ETag string
+}
+
+// Service ties an origin, a resizer and a cache together.
+type Service struct {
+	Origin Origin
+	Cache  *DiskCache
+	// Widths lists the widths clients may ask for. A width of 0 always means the
+	// original image.
+	Widths []int
+}
+
+// AllowedWidth reports whether width may be requested.
+func (s *Service) AllowedWidth(width int) bool {
+	if width == 0 {
+		return true
+	}
+
+	for _, w := range s.Widths {
+		if w == width {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get returns the cover stored at path, scaled to width. The cache key includes a hash
+// of the path, so changing a book's cover never serves the old image.
+func (s *Service) Get(ctx context.Context, bookID int64, path string, width int) (*Cover, error) {
+	sum := sha256.Sum256([]byte(path))
+	hash := hex.EncodeToString(sum[:8])
+	key := fmt.Sprintf("%d-%s-w%d", bookID, hash, width)
+
+	if data, modTime, ok := s.Cache.Get(key); ok {
+		// Entries cached before covers were checked may not be images, those are
+		// fetched again.
+		if cover, err := newCover(data, modTime, key); err == nil {
+			return cover, nil
+		}
+	}
+
+	data, err := s.Origin.Fetch(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The origin's response is served from our own domain, so it must be an image and
+	// never something a browser would run, like HTML or SVG.
+	if _, err := imageType(data); err != nil {
+		return nil, err
+	}
+
+	if width > 0 {
+		data, err = resize(data, width)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A cache write failure (full disk, permissions) shouldn't stop us from serving
+	// the image we already have.
+	modTime, err := s.Cache.Put(key, data)
+	if err != nil {
+		modTime = time.Now()
+	}
+
+	return newCover(data, modTime, key)
+}
+
+// newCover returns a cover of the image in data, with the content type of its format
+// rather than a sniffed one.
+func newCover(data []byte, modTime time.Time, key string) (*Cover, error) {
+	contentType, err := imageType(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cover{
+		Data:        data,
+		ContentType: contentType,
+		ModTime:     modTime,
+		ETag:        `"` + key + `"`,
+	}, nil
+}
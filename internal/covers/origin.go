@@ -0,0 +1,132 @@
+package covers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the origin doesn't have the requested cover.
+var ErrNotFound = errors.New("cover not found")
+
+// maxCoverBytes caps the size of a cover fetched from the origin.
+const maxCoverBytes = 10 << 20
+
+// Origin is where the original cover images come from. path is the value of the
+// Coverurl column of a book.
+type Origin interface {
+	Fetch(ctx context.Context, path string) ([]byte, error)
+}
+
+// HTTPOrigin fetches covers over HTTP from below BaseURL. Cover paths come from the
+// catalog, which admins and imports write to, so they're never fetched from anywhere
+// else: an absolute URL is only accepted when it points below BaseURL, and redirects
+// must stay on its host. Anything else is reported as ErrNotFound.
+type HTTPOrigin struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewHTTPOrigin returns an HTTPOrigin with a client which gives up after timeout.
+func NewHTTPOrigin(baseURL string, timeout time.Duration) *HTTPOrigin {
+	o := &HTTPOrigin{BaseURL: baseURL}
+	o.Client = &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			base, err := url.Parse(o.BaseURL)
+			if err != nil {
+				return err
+			}
+			if req.URL.Scheme != base.Scheme || req.URL.Host != base.Host {
+				return fmt.Errorf("cover origin redirected to %s", req.URL.Redacted())
+			}
+			if len(via) >= 10 {
+				return errors.New("cover origin redirected too many times")
+			}
+			return nil
+		},
+	}
+	return o
+}
+
+func (o *HTTPOrigin) Fetch(ctx context.Context, coverPath string) ([]byte, error) {
+	url, err := o.resolve(coverPath)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := o.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("cover origin returned %s for %s", res.Status, url)
+	}
+
+	// Read one byte more than the limit, so we can tell a cover which is exactly at
+	// the limit from one which is too large.
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxCoverBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > maxCoverBytes {
+		return nil, fmt.Errorf("cover at %s is larger than %d bytes", url, maxCoverBytes)
+	}
+
+	return body, nil
+}
+
+// resolve returns the URL of the cover at coverPath below BaseURL. Absolute URLs are
+// turned back into paths when they point below BaseURL; ".." can't climb out of it.
+func (o *HTTPOrigin) resolve(coverPath string) (string, error) {
+	base, err := url.Parse(o.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	basePath := strings.TrimSuffix(base.Path, "/") + "/"
+
+	ref, err := url.Parse(coverPath)
+	if err != nil {
+		return "", ErrNotFound
+	}
+
+	if ref.Scheme != "" || ref.Host != "" {
+		if ref.Scheme != base.Scheme || ref.Host != base.Host || ref.User != nil || !strings.HasPrefix(ref.Path, basePath) {
+			return "", ErrNotFound
+		}
+		ref.Path = strings.TrimPrefix(ref.Path, basePath)
+	}
+
+	// Cleaning the path as if it were rooted drops any ".." which would climb out of
+	// BaseURL.
+	clean := strings.TrimPrefix(path.Clean("/"+ref.Path), "/")
+	if clean == "" {
+		return "", ErrNotFound
+	}
+
+	u := *base
+	u.Path = basePath + clean
+	u.RawPath = ""
+	u.RawQuery = ref.RawQuery
+	u.Fragment = ""
+
+	return u.String(), nil
+}
@@ -0,0 +1,110 @@
+package covers
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+
+	// Register the decoders for the formats covers come in.
+	_ "image/gif"
+	_ "image/png"
+)
+
+// maxSourcePixels guards against decompression bombs: a tiny file which decodes into
+// an enormous image.
+const maxSourcePixels = 25_000_000
+
+// imageType returns the content type of the encoded image in data, which must be in
+// one of the formats covers come in.
+func imageType(data []byte) (string, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("cover is not an image: %w", err)
+	}
+
+	return "image/" + format, nil
+}
+
+// resize scales the encoded image in src down to width pixels wide, keeping the aspect
+// ratio, and encodes the result as a JPEG. Images which are already narrow enough are
+// re-encoded but never scaled up.
+func resize(src []byte, width int) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, fmt.Errorf("cover is too large to resize (%dx%d)", cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, scaleDown(img, width), &jpeg.Options{Quality: 85})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// scaleDown resizes img with a box filter: every destination pixel is the average of
+// the source pixels it covers. That is plenty for shrinking cover thumbnails and keeps
+// us on the standard library.
+func scaleDown(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if width <= 0 || width >= b.Dx() {
+		return img
+	}
+
+	height := int(math.Round(float64(b.Dy()) * float64(width) / float64(b.Dx())))
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xScale := float64(b.Dx()) / float64(width)
+	yScale := float64(b.Dy()) / float64(height)
+
+	for y := 0; y < height; y++ {
+		sy0 := b.Min.Y + int(float64(y)*yScale)
+		sy1 := b.Min.Y + int(float64(y+1)*yScale)
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+
+		for x := 0; x < width; x++ {
+			sx0 := b.Min.X + int(float64(x)*xScale)
+			sx1 := b.Min.X + int(float64(x+1)*xScale)
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,271 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+)
+
+// Search kinds recorded in search_queries.
+const (
+	SearchKindSearch  = "search"
+	SearchKindSuggest = "suggest"
+)
+
+// SearchEvent is a single search (or suggestion request) as recorded for analytics.
+type SearchEvent struct {
+	SearchID    string
+	Kind        string
+	Query       string
+	Filters     string
+	ResultCount int
+	Latency     time.Duration
+	Degraded    bool
+	// UserID is 0 for anonymous users.
+	UserID int64
+}
+
+// SearchClick is a click on a search result. Position is the 1-based rank of the
+// book in the results.
+type SearchClick struct {
+	SearchID string `json:"search_id"`
+	BookID   int64  `json:"book_id"`
+	Position int    `json:"position"`
+}
+
+// NewSearchID returns a random id which identifies a search in the analytics tables.
+func NewSearchID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func ValidateSearchClick(v *validator.Validator, click *SearchClick) {
+	_, err := hex.DecodeString(click.SearchID)
+	v.Check(len(click.SearchID) == 32 && err == nil, "search_id", "must be a valid search id")
+	v.Check(click.BookID > 0, "book_id", "must be a positive id")
+	v.Check(click.Position > 0, "position", "must be greater than zero")
+}
+
+// normalizeQuery is how queries are grouped in the reports, so "Golang " and "golang"
+// count as the same query.
+func normalizeQuery(query string) string {
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// QueryReport is a line of the top queries and zero result queries reports.
+type QueryReport struct {
+	Query          string  `json:"query"`
+	Searches       int     `json:"searches"`
+	AvgResultCount float64 `json:"avg_result_count"`
+	AvgLatencyMS   float64 `json:"avg_latency_ms"`
+	LastSearchedAt string  `json:"last_searched_at"`
+}
+
+// ClickThroughReport is a line of the click-through rate report. ClickThroughRate is
+// the share of searches with at least one click.
+type ClickThroughReport struct {
+	Query              string  `json:"query"`
+	Searches           int     `json:"searches"`
+	SearchesWithClicks int     `json:"searches_with_clicks"`
+	Clicks             int     `json:"clicks"`
+	ClickThroughRate   float64 `json:"click_through_rate"`
+	AvgClickPosition   float64 `json:"avg_click_position"`
+}
+
+// ReportFilters selects the searches a report covers.
+type ReportFilters struct {
+	From  time.Time
+	To    time.Time
+	Limit int
+}
+
+func ValidateReportFilters(v *validator.Validator, f ReportFilters) {
+	v.Check(f.To.After(f.From), "to", "must be after from")
+	v.Check(f.To.Sub(f.From) <= 366*24*time.Hour, "from", "must be at most a year before to")
+	v.Check(f.Limit > 0, "limit", "must be greater than zero")
+	v.Check(f.Limit <= 500, "limit", "must be a maximum of 500")
+}
+
+type AnalyticsModel struct {
+	DB *sql.DB
+}
+
+func (m AnalyticsModel) RecordSearch(event *SearchEvent) error {
+	query := `
+		INSERT INTO search_queries (search_id, kind, query_text, normalized_query, filters, result_count, latency_ms, degraded, user_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	var userID interface{}
+	if event.UserID != 0 {
+		userID = event.UserID
+	}
+
+	args := []interface{}{
+		event.SearchID,
+		event.Kind,
+		truncate(event.Query, 255),
+		truncate(normalizeQuery(event.Query), 255),
+		event.Filters,
+		event.ResultCount,
+		event.Latency.Milliseconds(),
+		event.Degraded,
+		userID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// RecordClick records a click on a search result. Clicks are only recorded for
+// searches we recorded, for any other search id the error is ErrRecordNotFound.
+func (m AnalyticsModel) RecordClick(click *SearchClick) error {
+	query := `
+		INSERT INTO search_clicks (search_id, updated_edited_id, position)
+		SELECT search_id, ?, ?
+		FROM search_queries
+		WHERE search_id = ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, click.BookID, click.Position, click.SearchID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// TopQueries returns the most frequent search queries.
+func (m AnalyticsModel) TopQueries(filters ReportFilters) ([]*QueryReport, error) {
+	return m.queryReport(filters, "")
+}
+
+// ZeroResultQueries returns the most frequent search queries which found nothing.
+func (m AnalyticsModel) ZeroResultQueries(filters ReportFilters) ([]*QueryReport, error) {
+	return m.queryReport(filters, "AND result_count = 0")
+}
+
+func (m AnalyticsModel) queryReport(filters ReportFilters, condition string) ([]*QueryReport, error) {
+	query := `
+		SELECT normalized_query, COUNT(*), AVG(result_count), AVG(latency_ms), MAX(created_at)
+		FROM search_queries
+		WHERE kind = 'search' AND created_at >= ? AND created_at < ? ` + condition + `
+		GROUP BY normalized_query
+		ORDER BY COUNT(*) DESC, normalized_query
+		LIMIT ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.From, filters.To, filters.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []*QueryReport{}
+
+	for rows.Next() {
+		var report QueryReport
+		var lastSearchedAt time.Time
+
+		err := rows.Scan(&report.Query, &report.Searches, &report.AvgResultCount, &report.AvgLatencyMS, &lastSearchedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		report.LastSearchedAt = lastSearchedAt.Format(time.RFC3339)
+		reports = append(reports, &report)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+// ClickThrough returns the click-through rate of the most frequent search queries.
+func (m AnalyticsModel) ClickThrough(filters ReportFilters) ([]*ClickThroughReport, error) {
+	query := `
+		SELECT q.normalized_query, COUNT(*), SUM(c.clicks > 0), COALESCE(SUM(c.clicks), 0),
+			COALESCE(SUM(c.position_sum) / NULLIF(SUM(c.clicks), 0), 0)
+		FROM search_queries q
+		LEFT JOIN (
+			SELECT search_id, COUNT(*) AS clicks, SUM(position) AS position_sum
+			FROM search_clicks
+			WHERE created_at >= ?
+			GROUP BY search_id
+		) c ON c.search_id = q.search_id
+		WHERE q.kind = 'search' AND q.created_at >= ? AND q.created_at < ?
+		GROUP BY q.normalized_query
+		ORDER BY COUNT(*) DESC, q.normalized_query
+		LIMIT ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.From, filters.From, filters.To, filters.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []*ClickThroughReport{}
+
+	for rows.Next() {
+		var report ClickThroughReport
+		var searchesWithClicks sql.NullInt64
+
+		err := rows.Scan(&report.Query, &report.Searches, &searchesWithClicks, &report.Clicks, &report.AvgClickPosition)
+		if err != nil {
+			return nil, err
+		}
+
+		report.SearchesWithClicks = int(searchesWithClicks.Int64)
+		if report.Searches > 0 {
+			report.ClickThroughRate = float64(report.SearchesWithClicks) / float64(report.Searches)
+		}
+
+		reports = append(reports, &report)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
@@ -11,6 +11,9 @@ import (
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/go-sql-driver/mysql"
+	"github.com/hafizmfadli/hello-nerds-api/internal/isbn"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
 )
 
 // use pointer string instead of string because
@@ -30,11 +33,28 @@ type Book struct {
 	Identifier *string `json:"identifier,omitempty"`
 	Quantity  int     `json:"stock,omitempty"`
 	Price     int64   `json:"price,omitempty"`
+	Version   int32   `json:"version,omitempty"`
+	// RatingAvg and RatingCount summarize the visible reviews of the book.
+	RatingAvg   float64 `json:"rating_avg,omitempty"`
+	RatingCount int     `json:"rating_count,omitempty"`
+	// Highlight holds the highlighted snippets returned by Elasticsearch, keyed by
+	// field name. It's only populated when highlighting was requested.
+	Highlight map[string][]string `json:"highlight,omitempty"`
 }
 
+// didYouMeanThreshold is the number of hits below which we consider a search to be
+// "poor" and offer the spelling suggestion from Elasticsearch to the client.
+const didYouMeanThreshold = 3
+
 type BookModel struct {
 	DB *sql.DB
 	ES *elasticsearch.Client
+	// Index is the name of the books index. In production this is an alias pointing
+	// at the current versioned index, see cmd/reindex.
+	Index string
+	// breaker trips when Elasticsearch keeps failing, so we stop waiting on it and
+	// fall back to MySQL straight away.
+	breaker *circuitBreaker
 }
 
 func (b BookModel) GetAll(filters Filters) ([]*Book, Metadata, error) {
@@ -48,7 +68,7 @@ func (b BookModel) GetAll(filters Filters) ([]*Book, Metadata, error) {
 			"query": {
 				"match": {
 					"Searchword": {
-						"query": "%s",
+						"query": %s,
 						"operator": "or",
 						"fuzziness": 1,
 						"prefix_length": 3,
@@ -57,82 +77,34 @@ func (b BookModel) GetAll(filters Filters) ([]*Book, Metadata, error) {
 				}
 			}
 		}	
-		`, filters.offset(), filters.limit(), filters.Searchword)
+		`, filters.offset(), filters.limit(), quote(filters.Searchword))
 	}else {
 		query = fmt.Sprintf(`
 		{
 			"from": %d,
 			"size": %d,
 			"query": {
-				"match": {
-					"Identifier": { 
-						"query": "%s",
-						"minimum_should_match": "100%%"
-					}
+				"term": {
+					"ISBN": %s
 				}
 			}
 		}
-		`, filters.offset(), filters.limit(), filters.ISBN)
+		`, filters.offset(), filters.limit(), quote(filters.ISBN))
 	}
 
-	
-	res, err := b.ES.Search(
-		b.ES.Search.WithIndex("books-v1"),
-		b.ES.Search.WithBody(buildQuery(query)),
-	)
+	query = withSearchExtras(query, filters)
 
+	res, err := b.search(query)
 	if err != nil {
+		// Keep browsing working while Elasticsearch is down.
+		if errors.Is(err, ErrSearchUnavailable) {
+			return b.fallbackSearch(filters)
+		}
 		return nil, Metadata{}, err
 	}
 	defer res.Body.Close()
 
-	results, totalRecords, err := b.parseElasticsearchResponse(res)
-
-	if err != nil {
-		return nil, Metadata{}, err
-	}
-
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
-
-	return results, metadata, nil
-}
-
-func (b BookModel) GetBookSuggestions (typeSearch string, filters Filters) ([]*Book, error) {
-	// filters will be use later
-	query := fmt.Sprintf(`
-	{
-		"query": {
-			"multi_match": {
-				"query": "%s",
-				"type": "bool_prefix", 
-				"fields": [
-					"Typesearch",
-					"Typesearch._2gram",
-					"Typesearch._3gram",
-					"Typesearch._index_prefix"
-				]
-			}
-		}
-	}
-	`, typeSearch)
-
-	res, err := b.ES.Search(
-		b.ES.Search.WithIndex("books-v1"),
-		b.ES.Search.WithBody(buildQuery(query)),
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	defer res.Body.Close()
-
-	results, _, err := b.parseElasticsearchResponse(res)
-	if err != nil {
-		return nil, err
-	}
-	
-	return results, nil
+	return b.parseSearchResponse(res, filters)
 }
 
 func (b BookModel) AdvanceFilterBooks (filters Filters) ([]*Book, Metadata, error) {
@@ -155,7 +127,7 @@ func (b BookModel) AdvanceFilterBooks (filters Filters) ([]*Book, Metadata, erro
 			const searchwordFilter = `
 			"match": {
 				"Searchword": {
-					"query": "%s",
+					"query": %s,
 					"operator": "or",
 					"fuzziness": 1,
 					"prefix_length": 3,
@@ -163,28 +135,25 @@ func (b BookModel) AdvanceFilterBooks (filters Filters) ([]*Book, Metadata, erro
 				}
 			}
 		`
-			filtersES = append(filtersES, fmt.Sprintf(searchwordFilter, filters.Searchword))
+			filtersES = append(filtersES, fmt.Sprintf(searchwordFilter, quote(filters.Searchword)))
 		}
 	}else {
 		const isbnFilter = `
-			"match": {
-				"Identifier": { 
-					"query": "%s",
-					"minimum_should_match": "100%%"
-				}
+			"term": {
+				"ISBN": %s
 			}
 		`
-			filtersES = append(filtersES, fmt.Sprintf(isbnFilter, filters.ISBN))
+			filtersES = append(filtersES, fmt.Sprintf(isbnFilter, quote(filters.ISBN)))
 	}
 
 	// filter author
 	if filters.Author != "" {
 		const authorFilter = `
 			"match": {
-				"Author": "%s"
+				"Author": %s
 			}
 		`
-		filtersES = append(filtersES, fmt.Sprintf(authorFilter, filters.Author))
+		filtersES = append(filtersES, fmt.Sprintf(authorFilter, quote(filters.Author)))
 	}
 
 
@@ -192,10 +161,10 @@ func (b BookModel) AdvanceFilterBooks (filters Filters) ([]*Book, Metadata, erro
 	if filters.Extension != "" && filters.Extension != "all" {
 		const extensionFilter = `
 			"match": {
-				"Extension": "%s"
+				"Extension": %s
 			}
 		`
-		filtersES = append(filtersES, fmt.Sprintf(extensionFilter, filters.Extension))
+		filtersES = append(filtersES, fmt.Sprintf(extensionFilter, quote(filters.Extension)))
 	}
 
 	// filter availability status 
@@ -245,27 +214,18 @@ func (b BookModel) AdvanceFilterBooks (filters Filters) ([]*Book, Metadata, erro
 	sb.WriteString("\n}")
 	sb.WriteString("\n}")
 
-	fmt.Println(sb.String())
-
-	res, err := b.ES.Search(
-		b.ES.Search.WithIndex("books-v1"),
-		b.ES.Search.WithBody(buildQuery(sb.String())),
-	)
+	query := withSearchExtras(sb.String(), filters)
 
+	res, err := b.search(query)
 	if err != nil {
+		if errors.Is(err, ErrSearchUnavailable) {
+			return b.fallbackSearch(filters)
+		}
 		return nil, Metadata{}, err
 	}
 	defer res.Body.Close()
 
-	results, totalRecords, err := b.parseElasticsearchResponse(res)
-
-	if err != nil {
-		return nil, Metadata{}, err
-	}
-
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
-
-	return results, metadata, nil
+	return b.parseSearchResponse(res, filters)
 }
 
 func (b BookModel) GetBook(id int64) (*Book, error) {
@@ -275,7 +235,7 @@ func (b BookModel) GetBook(id int64) (*Book, error) {
 	}
 
 	query := `
-		SELECT id, Title, Author, Coverurl, Extension, Year, Publisher, Language, Identifier, quantity, price
+		SELECT id, Title, Author, Coverurl, Extension, Year, Publisher, Language, Identifier, quantity, price, version, rating_avg, rating_count
 		FROM updated_edited
 		WHERE id = ?
 	`
@@ -297,6 +257,9 @@ func (b BookModel) GetBook(id int64) (*Book, error) {
 		&book.Identifier,
 		&book.Quantity,
 		&book.Price,
+		&book.Version,
+		&book.RatingAvg,
+		&book.RatingCount,
 	)
 
 	if err != nil {
@@ -311,43 +274,497 @@ func (b BookModel) GetBook(id int64) (*Book, error) {
 	return &book, nil
 }
 
+// GetBooks returns the books with the given ids. Ids which don't match a book are
+// silently skipped, so the result may be shorter than ids.
+func (b BookModel) GetBooks(ids []int64) ([]*Book, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	query := `
+		SELECT id, Title, Author, Coverurl, Extension, Year, Publisher, Language, Identifier, quantity, price, version, rating_avg, rating_count
+		FROM updated_edited
+		WHERE id IN (` + placeholders(len(ids)) + `)`
+
+	return b.queryBooks(query, int64Args(ids)...)
+}
+
+// ListAfter returns up to limit books with an id greater than afterID, ordered by id.
+// It's used to walk through the whole catalog in batches.
+func (b BookModel) ListAfter(afterID int64, limit int) ([]*Book, error) {
+	query := `
+		SELECT id, Title, Author, Coverurl, Extension, Year, Publisher, Language, Identifier, quantity, price, version, rating_avg, rating_count
+		FROM updated_edited
+		WHERE id > ?
+		ORDER BY id
+		LIMIT ?`
+
+	return b.queryBooks(query, afterID, limit)
+}
+
+// queryBooks runs a query selecting the columns of updated_edited in the same order as
+// GetBook and scans the rows into books.
+func (b BookModel) queryBooks(query string, args ...interface{}) ([]*Book, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := b.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var books []*Book
+
+	for rows.Next() {
+		var book Book
+
+		err := rows.Scan(
+			&book.ID,
+			&book.Title,
+			&book.Author,
+			&book.CoverUrl,
+			&book.Extension,
+			&book.Year,
+			&book.Publisher,
+			&book.Language,
+			&book.Identifier,
+			&book.Quantity,
+			&book.Price,
+			&book.Version,
+			&book.RatingAvg,
+			&book.RatingCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		books = append(books, &book)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+// Insert adds a new book to the catalog. The id and version are generated by the
+// database. The search index is updated asynchronously through the books outbox.
+func (b BookModel) Insert(book *Book) error {
+	query := `
+		INSERT INTO updated_edited (Title, Author, Coverurl, Extension, Year, Publisher, Language, Identifier, quantity, price)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	args := bookColumns(book)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := b.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	book.ID = id
+	book.Version = 1
+
+	return nil
+}
+
+// Update saves the changes to a book. The version number is used for optimistic
+// locking: if the record was changed since it was read, the update doesn't match any
+// row and we return ErrEditConflict.
+func (b BookModel) Update(book *Book) error {
+	query := `
+		UPDATE updated_edited
+		SET Title = ?, Author = ?, Coverurl = ?, Extension = ?, Year = ?, Publisher = ?, Language = ?,
+		Identifier = ?, quantity = ?, price = ?, version = version + 1
+		WHERE id = ? AND version = ?`
+
+	args := append(bookColumns(book), book.ID, book.Version)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := b.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	book.Version++
+
+	return nil
+}
+
+// ErrBookOrdered is returned when deleting a book which has been ordered. Deleting it
+// would take the order history (and the reviews, which hang off order items) with it.
+var ErrBookOrdered = errors.New("book has been ordered")
+
+// Delete removes a book from the catalog, along with the carts and collection entries
+// it's in. Books which have been ordered can't be deleted, ErrBookOrdered is returned
+// for them; set their stock to 0 instead.
+func (b BookModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the book first. Checkout locks it too before adding order items, so no
+	// order can sneak in between the check and the delete.
+	var ordered bool
+
+	query := `
+		SELECT EXISTS (SELECT 1 FROM order_items WHERE updated_edited_id = ue.id)
+		FROM updated_edited ue
+		WHERE ue.id = ?
+		FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&ordered)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if ordered {
+		return ErrBookOrdered
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM updated_edited WHERE id = ?`, id)
+	if err != nil {
+		// The foreign key of order_items refuses the delete as well, see migration
+		// 000023.
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1451 {
+			return ErrBookOrdered
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Upsert inserts the book, or overwrites the existing book with the same Identifier. It reports whether a new record was created.
+func (b BookModel) Upsert(book *Book) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Lock the existing row (if any) so two imports of the same book can't both decide
+	// to insert it.
+	var inserted bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT id FROM updated_edited WHERE Identifier = ? ORDER BY id LIMIT 1 FOR UPDATE`,
+		book.Identifier).Scan(&book.ID)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO updated_edited (Title, Author, Coverurl, Extension, Year, Publisher, Language, Identifier, quantity, price)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, bookColumns(book)...)
+		if err != nil {
+			return false, err
+		}
+
+		book.ID, err = result.LastInsertId()
+		if err != nil {
+			return false, err
+		}
+
+		inserted = true
+	case err != nil:
+		return false, err
+	default:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE updated_edited
+			SET Title = ?, Author = ?, Coverurl = ?, Extension = ?, Year = ?, Publisher = ?, Language = ?,
+			Identifier = ?, quantity = ?, price = ?, version = version + 1
+			WHERE id = ?`, append(bookColumns(book), book.ID)...)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT version FROM updated_edited WHERE id = ?`, book.ID).Scan(&book.Version)
+	if err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return inserted, nil
+}
+
+// FindIDByIdentifier returns the id of the book with the given Identifier.
+func (b BookModel) FindIDByIdentifier(identifier string) (int64, error) {
+	query := `
+		SELECT id FROM updated_edited WHERE Identifier = ? ORDER BY id LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+
+	err := b.DB.QueryRowContext(ctx, query, identifier).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return id, nil
+}
+
+// bookColumns returns the values of the writable updated_edited columns, in the order
+// used by the INSERT and UPDATE statements.
+func bookColumns(book *Book) []interface{} {
+	return []interface{}{
+		book.Title,
+		book.Author,
+		book.CoverUrl,
+		book.Extension,
+		book.Year,
+		book.Publisher,
+		book.Language,
+		book.Identifier,
+		book.Quantity,
+		book.Price,
+	}
+}
+
+func ValidateBook(v *validator.Validator, book *Book) {
+	v.Check(book.Title != nil && *book.Title != "", "title", "must be provided")
+	v.Check(book.Title == nil || len(*book.Title) <= 500, "title", "must not be more than 500 bytes long")
+
+	v.Check(book.Author != nil && *book.Author != "", "author", "must be provided")
+	v.Check(book.Author == nil || len(*book.Author) <= 300, "author", "must not be more than 300 bytes long")
+
+	// Identifier holds one or more ISBNs separated by commas, every one of them has to
+	// be valid.
+	v.Check(book.Identifier != nil && *book.Identifier != "", "identifier", "must be provided")
+	if book.Identifier != nil {
+		for _, number := range strings.Split(*book.Identifier, ",") {
+			if !isbn.Valid(number) {
+				v.AddError("identifier", "must be a comma separated list of valid ISBNs")
+				break
+			}
+		}
+	}
+
+	v.Check(book.Extension != nil && validator.In(*book.Extension, "pdf", "epub", "djvu"), "extension", "must be pdf, epub or djvu")
+
+	v.Check(book.Price > 0, "price", "must be greater than zero")
+	v.Check(book.Quantity >= 0, "stock", "must not be negative")
+}
+
+// withSearchExtras appends the optional "sort", "highlight" and "suggest" sections to a
+// search query. The query must be a single JSON object; the extras are spliced in right before
+// its closing brace.
+func withSearchExtras(query string, filters Filters) string {
+	var extras []string
+
+	// The default is Elasticsearch's relevance order. unmapped_type keeps the query
+	// working on an index which doesn't have the rating fields yet.
+	if filters.Sort == "rating" {
+		extras = append(extras, `
+		"sort": [
+			{ "rating_avg": { "order": "desc", "unmapped_type": "float" } },
+			{ "rating_count": { "order": "desc", "unmapped_type": "integer" } },
+			"_score"
+		]`)
+	}
+
+	// Title and Author aren't the fields we actually query (that's Searchword), so we
+	// have to turn off require_field_match to get snippets for them.
+	if filters.Highlight {
+		extras = append(extras, `
+		"highlight": {
+			"require_field_match": false,
+			"pre_tags": ["<em>"],
+			"post_tags": ["</em>"],
+			"fields": {
+				"Title": { "number_of_fragments": 0 },
+				"Author": { "number_of_fragments": 0 }
+			}
+		}`)
+	}
+
+	// Only keyword searches get a spelling suggestion, there is nothing to correct in
+	// an ISBN.
+	if filters.ISBN == "" && filters.Searchword != "" {
+		extras = append(extras, fmt.Sprintf(`
+		"suggest": {
+			"text": %s,
+			"did_you_mean": {
+				"phrase": {
+					"field": "Searchword",
+					"size": 1,
+					"gram_size": 1,
+					"max_errors": 2,
+					"direct_generator": [
+						{
+							"field": "Searchword",
+							"suggest_mode": "always"
+						}
+					],
+					"highlight": {
+						"pre_tag": "<em>",
+						"post_tag": "</em>"
+					}
+				}
+			}
+		}`, quote(filters.Searchword)))
+	}
+
+	if extras == nil {
+		return query
+	}
+
+	end := strings.LastIndex(query, "}")
+	if end < 0 {
+		return query
+	}
+
+	return query[:end] + "," + strings.Join(extras, ",") + "\n" + query[end:]
+}
+
+// parseSearchResponse turns the response of a paginated book search into the books,
+// the pagination metadata and, when the search returned only a handful of hits, a
+// "did you mean" suggestion.
+func (b BookModel) parseSearchResponse(res *esapi.Response, filters Filters) ([]*Book, Metadata, error) {
+	r, err := b.decodeElasticsearchResponse(res)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	results, err := r.books()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(r.Hits.Total.Value, filters.Page, filters.PageSize)
+
+	if r.Hits.Total.Value < didYouMeanThreshold {
+		metadata.DidYouMean = r.didYouMean(filters.Searchword)
+	}
+
+	return results, metadata, nil
+}
+
 // parseElasticsearchResponse return parsed elasticsearch response, total match document,
 // and error
 func (b BookModel) parseElasticsearchResponse (res *esapi.Response) ([]*Book, int, error) {
+	r, err := b.decodeElasticsearchResponse(res)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results, err := r.books()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, r.Hits.Total.Value, nil
+}
+
+// decodeElasticsearchResponse decodes the native elasticsearch response, turning any
+// error response into a Go error.
+func (b BookModel) decodeElasticsearchResponse(res *esapi.Response) (*esNativeResponse, error) {
 
 	if res.IsError() {
-		var e map[string]interface{}
-		if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
-			return nil, 0, err
-		}
-		return nil, 0, fmt.Errorf("[%s] %s: %s", res.Status(), e["error"].(map[string]interface{})["type"], e["error"].(map[string]interface{})["reason"])
+		return nil, esError(res)
 	}
 
 	var r esNativeResponse
 
 	// decode elasticsearch native response
 	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
+	return &r, nil
+}
+
+// books transforms the hits of elasticsearch native response to our custom response
+func (r *esNativeResponse) books() ([]*Book, error) {
 	if len(r.Hits.Hits) < 1 {
-		return nil, 0, nil
+		return nil, nil
 	}
 
-	// transform elasticsearch native response to our custome response
 	var results []*Book
 	for _, hit := range r.Hits.Hits {
 		var b Book
 		if err := json.Unmarshal(hit.Source, &b); err != nil {
-			return nil, 0, err
+			return nil, err
 		}
 		
-		// modify cover url if cover url doesn't have scheme and hostname
-		if !strings.HasPrefix(*b.CoverUrl, "http://") && !strings.HasPrefix(*b.CoverUrl, "https://") && *b.CoverUrl != "" {
-			*b.CoverUrl = "http://library.lol/covers/" + *b.CoverUrl
-		}
+		b.RewriteCoverURL()
+
+		b.Highlight = hit.Highlight
 		results = append(results, &b)
 	}
 
-	return results, r.Hits.Total.Value, nil
-}
\ No newline at end of file
+	return results, nil
+}
+
+// RewriteCoverURL points the cover url at our cover proxy (GET
+// /v1/books/detail/:id/cover), so clients never fetch covers from the origin host
+// directly. Only do this on books which are about to be sent to a client: the
+// original path stays in the database and is what the proxy fetches.
+func (b *Book) RewriteCoverURL() {
+	if b.CoverUrl == nil || *b.CoverUrl == "" {
+		return
+	}
+
+	url := fmt.Sprintf("/v1/books/detail/%d/cover", b.ID)
+	b.CoverUrl = &url
+}
+
+// didYouMean returns the best correction found by the "did_you_mean" phrase suggester,
+// or an empty string if there isn't one (or it's the same as what the user typed).
+func (r *esNativeResponse) didYouMean(searchword string) string {
+	for _, entry := range r.Suggest["did_you_mean"] {
+		for _, option := range entry.Options {
+			if !strings.EqualFold(option.Text, strings.TrimSpace(searchword)) {
+				return option.Text
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/hafizmfadli/hello-nerds-api/internal/isbn"
+)
+
+// search sends a search request for the books index through the circuit breaker.
+// Connection errors and 5xx/429 responses count as failures and are returned wrapped
+// in ErrSearchUnavailable; other error responses (e.g. a bad query) are returned to
+// the caller as a normal response.
+func (b BookModel) search(query string) (*esapi.Response, error) {
+	if !b.breaker.allow() {
+		return nil, ErrSearchUnavailable
+	}
+
+	res, err := b.ES.Search(
+		b.ES.Search.WithIndex(b.Index),
+		b.ES.Search.WithBody(buildQuery(query)),
+	)
+	if err != nil {
+		b.breaker.failure()
+		return nil, fmt.Errorf("%w: %v", ErrSearchUnavailable, err)
+	}
+
+	if res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusTooManyRequests {
+		defer res.Body.Close()
+		b.breaker.failure()
+		return nil, fmt.Errorf("%w: %v", ErrSearchUnavailable, esError(res))
+	}
+
+	b.breaker.success()
+	return res, nil
+}
+
+// fallbackSearch is a basic MySQL version of GetAll and AdvanceFilterBooks, used while
+// Elasticsearch is unavailable. Keywords are matched with the FULLTEXT index on Title,
+// Author and Publisher, ISBNs with a substring match on Identifier. There's no fuzzy
+// matching, highlighting or spelling suggestions, and the metadata is marked as
+// degraded so clients can tell.
+func (b BookModel) fallbackSearch(filters Filters) ([]*Book, Metadata, error) {
+	var conditions []string
+	var args []interface{}
+	var orderBy = "id"
+
+	if filters.ISBN != "" {
+		// Identifier may hold the ISBN-10 or the ISBN-13 of the book, so look for both.
+		isbn10, err := isbn.To10(filters.ISBN)
+		if err != nil {
+			isbn10 = filters.ISBN
+		}
+		conditions = append(conditions, "(Identifier LIKE ? OR Identifier LIKE ?)")
+		args = append(args, "%"+filters.ISBN+"%", "%"+isbn10+"%")
+	} else if filters.Searchword != "" {
+		conditions = append(conditions, "MATCH(Title, Author, Publisher) AGAINST (? IN NATURAL LANGUAGE MODE)")
+		args = append(args, filters.Searchword)
+	}
+
+	if filters.Author != "" {
+		conditions = append(conditions, "Author LIKE ?")
+		args = append(args, "%"+filters.Author+"%")
+	}
+
+	if filters.Extension != "" && filters.Extension != "all" {
+		conditions = append(conditions, "Extension = ?")
+		args = append(args, filters.Extension)
+	}
+
+	// filter availability status
+	// 1 : in stock
+	// 2 : currently unavailable
+	switch filters.Availability {
+	case 1:
+		conditions = append(conditions, "quantity > 0")
+	case 2:
+		conditions = append(conditions, "quantity = 0")
+	}
+
+	where := ""
+	if conditions != nil {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// Order keyword matches by relevance, like Elasticsearch would.
+	if filters.Sort == "rating" {
+		orderBy = "rating_avg DESC, rating_count DESC, id"
+	} else if filters.ISBN == "" && filters.Searchword != "" {
+		orderBy = "MATCH(Title, Author, Publisher) AGAINST (? IN NATURAL LANGUAGE MODE) DESC, id"
+		args = append(args, filters.Searchword)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, Title, Author, Coverurl, Extension, Year, Publisher, Language, Identifier, quantity, price, rating_avg, rating_count
+		FROM updated_edited
+		%s
+		ORDER BY %s
+		LIMIT ? OFFSET ?`, where, orderBy)
+
+	args = append(args, filters.limit(), filters.offset())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := b.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	var books []*Book
+
+	for rows.Next() {
+		var book Book
+
+		err := rows.Scan(
+			&totalRecords,
+			&book.ID,
+			&book.Title,
+			&book.Author,
+			&book.CoverUrl,
+			&book.Extension,
+			&book.Year,
+			&book.Publisher,
+			&book.Language,
+			&book.Identifier,
+			&book.Quantity,
+			&book.Price,
+			&book.RatingAvg,
+			&book.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		book.RewriteCoverURL()
+		books = append(books, &book)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	metadata.Degraded = true
+
+	return books, metadata, nil
+}
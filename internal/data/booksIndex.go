@@ -0,0 +1,229 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/hafizmfadli/hello-nerds-api/internal/isbn"
+)
+
+// esBook is the document we store in the books index. The field names follow the
+// columns of the updated_edited table (that's what the search queries are written
+// against), plus the Searchword and Typesearch fields which only exist in the index.
+type esBook struct {
+	ID         int64   `json:"id"`
+	Title      *string `json:"Title"`
+	Author     *string `json:"Author"`
+	CoverUrl   *string `json:"Coverurl"`
+	Extension  *string `json:"Extension"`
+	Year       *string `json:"Year"`
+	Publisher  *string `json:"Publisher"`
+	Language   *string `json:"Language"`
+	Identifier *string `json:"Identifier"`
+	// ISBN holds every valid ISBN from Identifier in the canonical ISBN-13 form, for
+	// exact lookups.
+	ISBN        []string `json:"ISBN"`
+	Quantity    int      `json:"quantity"`
+	Price       int64    `json:"price"`
+	RatingAvg   float64  `json:"rating_avg"`
+	RatingCount int      `json:"rating_count"`
+	// AuthorSlug and PublisherSlug identify the author and publisher pages, see
+	// Slugify.
+	AuthorSlug    string `json:"AuthorSlug,omitempty"`
+	PublisherSlug string `json:"PublisherSlug,omitempty"`
+	Searchword    string `json:"Searchword"`
+	Typesearch    string `json:"Typesearch"`
+}
+
+// newESBook builds the search document for a book. Searchword is what the keyword
+// search matches on, so it gets everything a user is likely to type; Typesearch backs
+// the search-as-you-type suggestions and only holds the title.
+func newESBook(book *Book) esBook {
+	var searchwords []string
+	for _, field := range []*string{book.Title, book.Author, book.Publisher, book.Identifier} {
+		if field != nil && *field != "" {
+			searchwords = append(searchwords, *field)
+		}
+	}
+
+	var typesearch string
+	if book.Title != nil {
+		typesearch = *book.Title
+	}
+
+	var isbns []string
+	if book.Identifier != nil {
+		isbns = isbn.ParseList(*book.Identifier)
+	}
+
+	var authorSlug, publisherSlug string
+	if book.Author != nil {
+		authorSlug = Slugify(*book.Author)
+	}
+	if book.Publisher != nil {
+		publisherSlug = Slugify(*book.Publisher)
+	}
+
+	return esBook{
+		ID:            book.ID,
+		Title:         book.Title,
+		Author:        book.Author,
+		CoverUrl:      book.CoverUrl,
+		Extension:     book.Extension,
+		Year:          book.Year,
+		Publisher:     book.Publisher,
+		Language:      book.Language,
+		Identifier:    book.Identifier,
+		ISBN:          isbns,
+		Quantity:      book.Quantity,
+		Price:         book.Price,
+		RatingAvg:     book.RatingAvg,
+		RatingCount:   book.RatingCount,
+		AuthorSlug:    authorSlug,
+		PublisherSlug: publisherSlug,
+		Searchword:    strings.Join(searchwords, " "),
+		Typesearch:    typesearch,
+	}
+}
+
+// BulkIndex creates or replaces the search documents of many books with a single bulk
+// request. All the documents get the given version, see BulkSync.
+func (b BookModel) BulkIndex(books []*Book, version int64) error {
+	versions := make(map[int64]int64, len(books))
+	for _, book := range books {
+		versions[book.ID] = version
+	}
+
+	return b.BulkSync(books, nil, versions)
+}
+
+// BulkSync brings the search index in line with the catalog in a single bulk request:
+// the documents of books are created or replaced, and the documents of the books in
+// deletedIDs are removed.
+//
+// versions holds the version of every document, the id of the latest books outbox
+// entry of the book. Documents are written with external versioning, so Elasticsearch
+// refuses to replace a document with an older version of it. That keeps a slow
+// writer which read the book from MySQL earlier from undoing a newer write, when
+// several synchronizers process the outbox at the same time. A refused write isn't an
+// error: the index holds something newer already.
+func (b BookModel) BulkSync(books []*Book, deletedIDs []int64, versions map[int64]int64) error {
+	return b.BulkSyncIndex(b.Index, books, deletedIDs, versions)
+}
+
+// BulkSyncIndex is BulkSync for the given index instead of b.Index, for writing to an
+// index which is being rebuilt and isn't behind the alias yet.
+func (b BookModel) BulkSyncIndex(index string, books []*Book, deletedIDs []int64, versions map[int64]int64) error {
+	if len(books) == 0 && len(deletedIDs) == 0 {
+		return nil
+	}
+
+	// The bulk API takes newline delimited JSON: an action line, followed by the
+	// document source for index actions.
+	var buf bytes.Buffer
+	for _, book := range books {
+		js, err := json.Marshal(newESBook(book))
+		if err != nil {
+			return err
+		}
+
+		buf.WriteString(bulkAction("index", index, book.ID, versions))
+		buf.WriteByte('\n')
+		buf.Write(js)
+		buf.WriteByte('\n')
+	}
+
+	for _, id := range deletedIDs {
+		buf.WriteString(bulkAction("delete", index, id, versions))
+		buf.WriteByte('\n')
+	}
+
+	res, err := b.ES.Bulk(&buf)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return esError(res)
+	}
+
+	return decodeBulkResponse(res)
+}
+
+// bulkAction returns the action line of a bulk request for a document, with the
+// version of the document if there's one in versions.
+func bulkAction(action, index string, id int64, versions map[int64]int64) string {
+	version, ok := versions[id]
+	if !ok {
+		return fmt.Sprintf(`{%q:{"_index":%s,"_id":"%d"}}`, action, quote(index), id)
+	}
+
+	return fmt.Sprintf(`{%q:{"_index":%s,"_id":"%d","version":%d,"version_type":"external"}}`, action, quote(index), id, version)
+}
+
+// esBulkResponse is the part of the bulk API response we care about.
+type esBulkResponse struct {
+	Errors bool
+	Items  []map[string]struct {
+		ID     string `json:"_id"`
+		Status int
+		Error  struct {
+			Type   string
+			Reason string
+		}
+	}
+}
+
+// decodeBulkResponse checks the per-item results of a bulk request. The bulk API
+// answers 200 OK even when some of the items failed, so we have to look at every item.
+func decodeBulkResponse(res *esapi.Response) error {
+	var r esBulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return err
+	}
+
+	if !r.Errors {
+		return nil
+	}
+
+	var failed int
+	var firstErr string
+	for _, item := range r.Items {
+		for _, result := range item {
+			// A 404 on a delete just means the document is already gone, and a 409
+			// means the index holds a newer version of the document, see BulkSync.
+			if result.Status < 300 || result.Status == 404 || result.Status == 409 {
+				continue
+			}
+			failed++
+			if firstErr == "" {
+				firstErr = fmt.Sprintf("document %s: %s: %s", result.ID, result.Error.Type, result.Error.Reason)
+			}
+		}
+	}
+
+	if failed == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("bulk request: %d of %d items failed, first error: %s", failed, len(r.Items), firstErr)
+}
+
+// esError converts an elasticsearch error response into a Go error.
+func esError(res *esapi.Response) error {
+	var e map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
+		return err
+	}
+
+	cause, ok := e["error"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("[%s] %v", res.Status(), e["error"])
+	}
+
+	return fmt.Errorf("[%s] %s: %s", res.Status(), cause["type"], cause["reason"])
+}
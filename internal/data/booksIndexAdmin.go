@@ -0,0 +1,373 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// esObject is a shorthand for building Elasticsearch request bodies.
+type esObject map[string]interface{}
+
+// SearchAnalysis holds the admin-managed parts of the search analyzer: synonym rules
+// in the Solr format ("js, javascript" or "ebook => e-book") and stopwords.
+type SearchAnalysis struct {
+	Synonyms  []string
+	Stopwords []string
+}
+
+// booksIndexDefinition returns the settings and mappings of the books index. The field
+// names follow esBook.
+func booksIndexDefinition(analysis SearchAnalysis) esObject {
+	return esObject{
+		"settings": esObject{
+			"number_of_shards": 1,
+			"analysis":         booksAnalysis(analysis),
+		},
+		"mappings": booksMappings(),
+	}
+}
+
+// booksAnalysis returns the analysis settings of the books index. Text is indexed with
+// books_text and searched with books_search, which adds the synonyms and stopwords on
+// top. Keeping them out of the indexed text means the documents don't depend on them,
+// although analysis settings can still only be changed by building a new index.
+func booksAnalysis(analysis SearchAnalysis) esObject {
+	filters := esObject{}
+	searchFilters := []string{"lowercase", "asciifolding"}
+
+	// Synonym rules are analyzed with the filters before them, so they come before
+	// the stopwords: a rule containing a stopword would otherwise be rejected.
+	if len(analysis.Synonyms) > 0 {
+		filters["books_synonyms"] = esObject{
+			"type":     "synonym_graph",
+			"synonyms": analysis.Synonyms,
+		}
+		searchFilters = append(searchFilters, "books_synonyms")
+	}
+
+	if len(analysis.Stopwords) > 0 {
+		filters["books_stopwords"] = esObject{
+			"type":      "stop",
+			"stopwords": analysis.Stopwords,
+		}
+		searchFilters = append(searchFilters, "books_stopwords")
+	}
+
+	return esObject{
+		"filter": filters,
+		"analyzer": esObject{
+			"books_text": esObject{
+				"type":      "custom",
+				"tokenizer": "standard",
+				"filter":    []string{"lowercase", "asciifolding"},
+			},
+			"books_search": esObject{
+				"type":      "custom",
+				"tokenizer": "standard",
+				"filter":    searchFilters,
+			},
+		},
+		"normalizer": esObject{
+			"lowercase": esObject{
+				"type":   "custom",
+				"filter": []string{"lowercase", "asciifolding"},
+			},
+		},
+	}
+}
+
+// booksMappings returns the mappings of the books index.
+func booksMappings() esObject {
+	text := func() esObject {
+		return esObject{"type": "text", "analyzer": "books_text", "search_analyzer": "books_search"}
+	}
+
+	// Author and Publisher also get a keyword sub-field, so we can aggregate on the
+	// exact values.
+	textWithKeyword := func() esObject {
+		field := text()
+		field["fields"] = esObject{
+			"keyword": esObject{"type": "keyword", "ignore_above": 256},
+		}
+		return field
+	}
+
+	return esObject{
+		"dynamic": "strict",
+		"properties": esObject{
+			"id":            esObject{"type": "long"},
+			"Title":         textWithKeyword(),
+			"Author":        textWithKeyword(),
+			"Publisher":     textWithKeyword(),
+			"Coverurl":      esObject{"type": "keyword", "index": false},
+			"Extension":     esObject{"type": "keyword", "normalizer": "lowercase"},
+			"Year":          esObject{"type": "keyword"},
+			"Language":      esObject{"type": "keyword"},
+			"Identifier":    text(),
+			"ISBN":          esObject{"type": "keyword"},
+			"quantity":      esObject{"type": "integer"},
+			"price":         esObject{"type": "long"},
+			"rating_avg":    esObject{"type": "float"},
+			"rating_count":  esObject{"type": "integer"},
+			"AuthorSlug":    esObject{"type": "keyword"},
+			"PublisherSlug": esObject{"type": "keyword"},
+			"Searchword":    text(),
+			"Typesearch":    esObject{"type": "search_as_you_type", "analyzer": "books_text"},
+		},
+	}
+}
+
+// CreateIndex creates a new, empty books index with the given name and search analysis.
+// Refreshing is turned off, since the index is meant to be bulk loaded; call
+// FinishLoading once that's done.
+func (b BookModel) CreateIndex(name string, analysis SearchAnalysis) error {
+	definition := booksIndexDefinition(analysis)
+	definition["settings"].(esObject)["refresh_interval"] = "-1"
+
+	js, err := json.Marshal(definition)
+	if err != nil {
+		return err
+	}
+
+	res, err := b.ES.Indices.Create(name, b.ES.Indices.Create.WithBody(bytes.NewReader(js)))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return esError(res)
+	}
+
+	return nil
+}
+
+// FinishLoading turns refreshing back on for an index created with CreateIndex and
+// refreshes it, so every loaded document is searchable.
+func (b BookModel) FinishLoading(name string) error {
+	res, err := b.ES.Indices.PutSettings(
+		strings.NewReader(`{"index": {"refresh_interval": "1s"}}`),
+		b.ES.Indices.PutSettings.WithIndex(name),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return esError(res)
+	}
+
+	refresh, err := b.ES.Indices.Refresh(b.ES.Indices.Refresh.WithIndex(name))
+	if err != nil {
+		return err
+	}
+	defer refresh.Body.Close()
+
+	if refresh.IsError() {
+		return esError(refresh)
+	}
+
+	return nil
+}
+
+// PutMapping adds the fields of the current mappings which are missing from the live
+// index (b.Index). Elasticsearch only allows adding fields to a mapping, so changing an
+// existing field still needs a full reindex. The books_search analyzer must exist on
+// the index, so indices created before it was added need a full reindex too.
+func (b BookModel) PutMapping() error {
+	js, err := json.Marshal(booksMappings())
+	if err != nil {
+		return err
+	}
+
+	res, err := b.ES.Indices.PutMapping(bytes.NewReader(js), b.ES.Indices.PutMapping.WithIndex(b.Index))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return esError(res)
+	}
+
+	return nil
+}
+
+// CountDocuments returns the number of documents in the given index.
+func (b BookModel) CountDocuments(name string) (int, error) {
+	res, err := b.ES.Count(b.ES.Count.WithIndex(name))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return 0, esError(res)
+	}
+
+	var r struct {
+		Count int
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return 0, err
+	}
+
+	return r.Count, nil
+}
+
+// CountAll returns the number of books in the catalog.
+func (b BookModel) CountAll() (int, error) {
+	query := `SELECT COUNT(*) FROM updated_edited`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var count int
+
+	err := b.DB.QueryRowContext(ctx, query).Scan(&count)
+	return count, err
+}
+
+// AliasedIndices returns the indices the b.Index alias currently points at. If b.Index
+// is not an alias but a plain index (how the books index started out) the second
+// return value is true.
+func (b BookModel) AliasedIndices() ([]string, bool, error) {
+	res, err := b.ES.Indices.GetAlias(b.ES.Indices.GetAlias.WithName(b.Index))
+	if err != nil {
+		return nil, false, err
+	}
+	defer res.Body.Close()
+
+	// A 404 means there's no alias with that name; check whether there's an index.
+	if res.StatusCode == 404 {
+		exists, err := b.ES.Indices.Exists([]string{b.Index})
+		if err != nil {
+			return nil, false, err
+		}
+		defer exists.Body.Close()
+
+		return nil, exists.StatusCode == 200, nil
+	}
+
+	if res.IsError() {
+		return nil, false, esError(res)
+	}
+
+	// The response is keyed by the index names.
+	var r map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, false, err
+	}
+
+	var indices []string
+	for name := range r {
+		indices = append(indices, name)
+	}
+
+	return indices, false, nil
+}
+
+// SwapAlias atomically points the b.Index alias at newIndex, removing it from the
+// oldIndices. If removeConcrete is set, b.Index is a plain index which is deleted in
+// the same request, so the alias can take over its name.
+func (b BookModel) SwapAlias(newIndex string, oldIndices []string, removeConcrete bool) error {
+	var actions []esObject
+
+	if removeConcrete {
+		actions = append(actions, esObject{"remove_index": esObject{"index": b.Index}})
+	}
+
+	for _, old := range oldIndices {
+		actions = append(actions, esObject{"remove": esObject{"index": old, "alias": b.Index}})
+	}
+
+	actions = append(actions, esObject{"add": esObject{"index": newIndex, "alias": b.Index}})
+
+	js, err := json.Marshal(esObject{"actions": actions})
+	if err != nil {
+		return err
+	}
+
+	res, err := b.ES.Indices.UpdateAliases(bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return esError(res)
+	}
+
+	return nil
+}
+
+// DeleteIndices deletes the given indices.
+func (b BookModel) DeleteIndices(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	res, err := b.ES.Indices.Delete(names)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return esError(res)
+	}
+
+	return nil
+}
+
+// AnalyzedToken is a token produced by an analyzer.
+type AnalyzedToken struct {
+	Token    string `json:"token"`
+	Type     string `json:"type"`
+	Position int    `json:"position"`
+}
+
+// Analyze runs text through the search analyzer of the live index, or through the
+// index analyzer when index is set, to show how a query is matched.
+func (b BookModel) Analyze(text string, index bool) ([]AnalyzedToken, error) {
+	analyzer := "books_search"
+	if index {
+		analyzer = "books_text"
+	}
+
+	js, err := json.Marshal(esObject{"analyzer": analyzer, "text": text})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.ES.Indices.Analyze(
+		b.ES.Indices.Analyze.WithIndex(b.Index),
+		b.ES.Indices.Analyze.WithBody(bytes.NewReader(js)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, esError(res)
+	}
+
+	var r struct {
+		Tokens []AnalyzedToken
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+
+	if r.Tokens == nil {
+		r.Tokens = []AnalyzedToken{}
+	}
+
+	return r.Tokens, nil
+}
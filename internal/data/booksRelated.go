@@ -0,0 +1,99 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Related returns up to limit books which look like the book with the given id, using
+// an Elasticsearch more_like_this query on the title, author and publisher. The book
+// itself is never part of the result. Recommendations are a nice to have, so when
+// Elasticsearch is unavailable we return no books instead of an error, and report the
+// result as degraded so clients can tell it apart from a book without related ones.
+func (b BookModel) Related(id int64, limit int) ([]*Book, bool, error) {
+	query := fmt.Sprintf(`{
+		"size": %d,
+		"query": {
+			"more_like_this": {
+				"fields": ["Title", "Author", "Publisher"],
+				"like": [{"_index": %s, "_id": "%d"}],
+				"min_term_freq": 1,
+				"min_doc_freq": 1,
+				"max_query_terms": 25
+			}
+		}
+	}`, limit, quote(b.Index), id)
+
+	res, err := b.search(query)
+	if err != nil {
+		if errors.Is(err, ErrSearchUnavailable) {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+	defer res.Body.Close()
+
+	books, _, err := b.parseElasticsearchResponse(res)
+	return books, false, err
+}
+
+// AlsoBought returns up to limit books which were ordered together with the book with
+// the given id, the most frequent companions first. Two books count as bought together
+// when they're items of the same order. The counts come from the book_copurchases
+// table, so orders show up once RefreshAlsoBought ran.
+func (b BookModel) AlsoBought(id int64, limit int) ([]*Book, error) {
+	query := `
+		SELECT ue.id, ue.Title, ue.Author, ue.Coverurl, ue.Extension, ue.Year, ue.Publisher, ue.Language, ue.Identifier, ue.quantity, ue.price, ue.version, ue.rating_avg, ue.rating_count
+		FROM book_copurchases bc
+		INNER JOIN updated_edited ue ON ue.id = bc.other_id
+		WHERE bc.updated_edited_id = ?
+		ORDER BY bc.orders DESC, ue.id
+		LIMIT ?`
+
+	books, err := b.queryBooks(query, id, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, book := range books {
+		book.RewriteCoverURL()
+	}
+
+	return books, nil
+}
+
+// RefreshAlsoBought recomputes the book_copurchases table from the order history. The
+// table is replaced in a single transaction, so AlsoBought keeps reading the previous
+// counts until the new ones are complete.
+func (b BookModel) RefreshAlsoBought() error {
+	// Counting every pair of the whole order history takes a while on a large shop.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM book_copurchases`)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO book_copurchases (updated_edited_id, other_id, orders)
+		SELECT this.updated_edited_id, other.updated_edited_id, COUNT(DISTINCT this.order_id)
+		FROM order_items this
+		INNER JOIN order_items other ON other.order_id = this.order_id AND other.updated_edited_id <> this.updated_edited_id
+		GROUP BY this.updated_edited_id, other.updated_edited_id`
+
+	_, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,260 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Suggestion is a single search-as-you-type suggestion. Highlighted is Text (HTML
+// escaped) with the parts matching the typed prefixes wrapped in <em> tags. Title
+// suggestions carry the id of a matching book, author and publisher suggestions the
+// slug of their browse page and the number of matching books.
+type Suggestion struct {
+	Text        string `json:"text"`
+	Highlighted string `json:"highlighted"`
+	BookID      int64  `json:"book_id,omitempty"`
+	Slug        string `json:"slug,omitempty"`
+	Count       int    `json:"count,omitempty"`
+}
+
+// Suggestions groups the suggestions for what the user typed so far.
+type Suggestions struct {
+	Titles     []Suggestion `json:"titles"`
+	Authors    []Suggestion `json:"authors"`
+	Publishers []Suggestion `json:"publishers"`
+}
+
+// GetBookSuggestions returns up to limit suggestions of each kind for typeSearch.
+// Only books matching the extension and availability filters are considered. Titles
+// come from the search_as_you_type Typesearch field, authors and publishers from
+// aggregations over the books whose author or publisher matches the typed prefix.
+func (b BookModel) GetBookSuggestions(typeSearch string, filters Filters, limit int) (*Suggestions, error) {
+	suggestions := &Suggestions{
+		Titles:     []Suggestion{},
+		Authors:    []Suggestion{},
+		Publishers: []Suggestion{},
+	}
+
+	if strings.TrimSpace(typeSearch) == "" {
+		return suggestions, nil
+	}
+
+	filterClauses := strings.Join(suggestFilters(filters), ",")
+
+	// Fetch more title hits than needed, identical titles (different editions or
+	// formats of a book) are folded into one suggestion below. The author and
+	// publisher aggregations are global so they don't depend on the title matches,
+	// which means they have to apply the filters themselves.
+	query := fmt.Sprintf(`{
+		"size": %d,
+		"_source": ["id", "Title"],
+		"query": {
+			"bool": {
+				"must": {
+					"multi_match": {
+						"query": %[2]s,
+						"type": "bool_prefix",
+						"fields": [
+							"Typesearch",
+							"Typesearch._2gram",
+							"Typesearch._3gram",
+							"Typesearch._index_prefix"
+						]
+					}
+				},
+				"filter": [%[3]s]
+			}
+		},
+		"aggs": {
+			"all": {
+				"global": {},
+				"aggs": {
+					"authors": {
+						"filter": {
+							"bool": {
+								"must": { "match_bool_prefix": { "Author": %[2]s } },
+								"filter": [%[3]s]
+							}
+						},
+						"aggs": { "names": { "terms": { "field": "Author.keyword", "size": %[4]d } } }
+					},
+					"publishers": {
+						"filter": {
+							"bool": {
+								"must": { "match_bool_prefix": { "Publisher": %[2]s } },
+								"filter": [%[3]s]
+							}
+						},
+						"aggs": { "names": { "terms": { "field": "Publisher.keyword", "size": %[4]d } } }
+					}
+				}
+			}
+		}
+	}`, limit*3, quote(typeSearch), filterClauses, limit*2)
+
+	res, err := b.search(query)
+	if err != nil {
+		// Suggestions are a nice-to-have, so we simply don't offer any while
+		// Elasticsearch is down.
+		if errors.Is(err, ErrSearchUnavailable) {
+			return suggestions, nil
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	r, err := b.decodeElasticsearchResponse(res)
+	if err != nil {
+		return nil, err
+	}
+
+	books, err := r.books()
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := strings.Fields(strings.ToLower(typeSearch))
+
+	seen := make(map[string]bool)
+	for _, book := range books {
+		if book.Title == nil || *book.Title == "" {
+			continue
+		}
+
+		key := strings.ToLower(strings.Join(strings.Fields(*book.Title), " "))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		suggestions.Titles = append(suggestions.Titles, Suggestion{
+			Text:        *book.Title,
+			Highlighted: highlightPrefixes(*book.Title, prefixes),
+			BookID:      book.ID,
+		})
+
+		if len(suggestions.Titles) == limit {
+			break
+		}
+	}
+
+	var all struct {
+		Authors struct {
+			Names esTermsAggregation
+		}
+		Publishers struct {
+			Names esTermsAggregation
+		}
+	}
+	if err := r.aggregation("all", &all); err != nil {
+		return nil, err
+	}
+
+	suggestions.Authors = contributorSuggestions(all.Authors.Names, prefixes, limit)
+	suggestions.Publishers = contributorSuggestions(all.Publishers.Names, prefixes, limit)
+
+	return suggestions, nil
+}
+
+// suggestFilters returns the filter clauses for the extension and availability
+// filters.
+func suggestFilters(filters Filters) []string {
+	var clauses []string
+
+	if filters.Extension != "" && filters.Extension != "all" {
+		clauses = append(clauses, fmt.Sprintf(`{ "term": { "Extension": %s } }`, quote(filters.Extension)))
+	}
+
+	// 1 : in stock
+	// 2 : currently unavailable
+	switch filters.Availability {
+	case 1:
+		clauses = append(clauses, `{ "range": { "quantity": { "gte": 1 } } }`)
+	case 2:
+		clauses = append(clauses, `{ "range": { "quantity": { "lte": 0 } } }`)
+	}
+
+	return clauses
+}
+
+// contributorSuggestions turns the buckets of an author or publisher aggregation into
+// suggestions. Names which only differ in case or punctuation share a slug and are
+// merged into the most common spelling.
+func contributorSuggestions(agg esTermsAggregation, prefixes []string, limit int) []Suggestion {
+	suggestions := []Suggestion{}
+	index := make(map[string]int)
+
+	for _, bucket := range agg.Buckets {
+		slug := Slugify(bucket.Key)
+		if slug == "" {
+			continue
+		}
+
+		if i, ok := index[slug]; ok {
+			suggestions[i].Count += bucket.DocCount
+			continue
+		}
+
+		if len(suggestions) == limit {
+			continue
+		}
+
+		index[slug] = len(suggestions)
+		suggestions = append(suggestions, Suggestion{
+			Text:        bucket.Key,
+			Highlighted: highlightPrefixes(bucket.Key, prefixes),
+			Slug:        slug,
+			Count:       bucket.DocCount,
+		})
+	}
+
+	return suggestions
+}
+
+// highlightPrefixes HTML escapes text and wraps the start of every word which begins
+// with one of the (lower case) prefixes in <em> tags, using the longest matching
+// prefix.
+func highlightPrefixes(text string, prefixes []string) string {
+	var sb strings.Builder
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if !isWordRune(runes[i]) {
+			sb.WriteString(html.EscapeString(string(runes[i])))
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(runes) && isWordRune(runes[end]) {
+			end++
+		}
+		word := runes[i:end]
+
+		matched := 0
+		for _, prefix := range prefixes {
+			p := []rune(prefix)
+			if len(p) > matched && len(p) <= len(word) && strings.ToLower(string(word[:len(p)])) == prefix {
+				matched = len(p)
+			}
+		}
+
+		if matched > 0 {
+			sb.WriteString("<em>")
+			sb.WriteString(html.EscapeString(string(word[:matched])))
+			sb.WriteString("</em>")
+		}
+		sb.WriteString(html.EscapeString(string(word[matched:])))
+
+		i = end
+	}
+
+	return sb.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,91 @@
+package data
+
+import (
+	"sync"
+	"time"
+)
+
+// Define a breakerState type for the states of the circuit breaker.
+type breakerState int8
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker stops us from hammering a dependency which is down. After threshold
+// consecutive failures it opens and allow() returns false for the cooldown period.
+// Then a single probe request is let through: if it succeeds the breaker closes
+// again, otherwise it stays open for another cooldown.
+//
+// A nil *circuitBreaker always allows requests.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     breakerState
+	failures  int
+	changedAt time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow reports whether a request may be sent.
+func (cb *circuitBreaker) allow() bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerOpen, breakerHalfOpen:
+		// In the half-open state a probe is already in flight. If it never reports
+		// back, let another one through after the cooldown.
+		if time.Since(cb.changedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = breakerHalfOpen
+		cb.changedAt = time.Now()
+		return true
+	default:
+		return true
+	}
+}
+
+// success records a successful request and closes the breaker.
+func (cb *circuitBreaker) success() {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.state = breakerClosed
+	cb.failures = 0
+}
+
+// failure records a failed request, opening the breaker if needed.
+func (cb *circuitBreaker) failure() {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+
+	if cb.state == breakerHalfOpen || cb.failures >= cb.threshold {
+		cb.state = breakerOpen
+		cb.changedAt = time.Now()
+	}
+}
@@ -0,0 +1,171 @@
+package data
+
+import (
+	"fmt"
+)
+
+// Contributor kinds, for GetContributor and SuggestContributors.
+const (
+	ContributorAuthor    = "author"
+	ContributorPublisher = "publisher"
+)
+
+// contributorFields maps a contributor kind to its fields in the books index: the
+// text field, its keyword sub-field and the slug field.
+var contributorFields = map[string]struct {
+	text, keyword, slug string
+}{
+	ContributorAuthor:    {"Author", "Author.keyword", "AuthorSlug"},
+	ContributorPublisher: {"Publisher", "Publisher.keyword", "PublisherSlug"},
+}
+
+// Bucket is a value with the number of books which have it.
+type Bucket struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Contributor is an author or a publisher, with a summary of their books.
+type Contributor struct {
+	Name         string   `json:"name"`
+	Slug         string   `json:"slug"`
+	BookCount    int      `json:"book_count"`
+	InStockCount *int     `json:"in_stock_count,omitempty"`
+	Languages    []Bucket `json:"languages,omitempty"`
+	Years        []Bucket `json:"years,omitempty"`
+}
+
+// GetContributor returns the author or publisher (depending on kind) with the given
+// slug along with a page of their books, newest first. Everything comes from a single
+// search: the books are the hits, the summary is built from aggregations on the same
+// hits. ErrRecordNotFound is returned when no book has the slug. Documents written
+// before the slug fields existed get them from the outbox backfill (migration 000009).
+func (b BookModel) GetContributor(kind, slug string, filters Filters) (*Contributor, []*Book, Metadata, error) {
+	fields, ok := contributorFields[kind]
+	if !ok {
+		return nil, nil, Metadata{}, fmt.Errorf("unknown contributor kind %q", kind)
+	}
+
+	query := fmt.Sprintf(`{
+		"from": %d,
+		"size": %d,
+		"track_total_hits": true,
+		"query": {
+			"term": { %s: %s }
+		},
+		"sort": [
+			{ "Year": { "order": "desc", "unmapped_type": "keyword" } },
+			{ "Title.keyword": { "order": "asc", "unmapped_type": "keyword" } }
+		],
+		"aggs": {
+			"name": { "terms": { "field": %s, "size": 1 } },
+			"languages": { "terms": { "field": "Language", "size": 20 } },
+			"years": { "terms": { "field": "Year", "size": 100, "order": { "_key": "desc" } } },
+			"in_stock": { "filter": { "range": { "quantity": { "gt": 0 } } } }
+		}
+	}`, filters.offset(), filters.limit(), quote(fields.slug), quote(slug), quote(fields.keyword))
+
+	res, err := b.search(query)
+	if err != nil {
+		return nil, nil, Metadata{}, err
+	}
+	defer res.Body.Close()
+
+	r, err := b.decodeElasticsearchResponse(res)
+	if err != nil {
+		return nil, nil, Metadata{}, err
+	}
+
+	if r.Hits.Total.Value == 0 {
+		return nil, nil, Metadata{}, ErrRecordNotFound
+	}
+
+	books, err := r.books()
+	if err != nil {
+		return nil, nil, Metadata{}, err
+	}
+
+	var name, languages, years esTermsAggregation
+	var inStock esFilterAggregation
+
+	for aggName, dst := range map[string]interface{}{
+		"name":      &name,
+		"languages": &languages,
+		"years":     &years,
+		"in_stock":  &inStock,
+	} {
+		if err := r.aggregation(aggName, dst); err != nil {
+			return nil, nil, Metadata{}, err
+		}
+	}
+
+	contributor := &Contributor{
+		Slug:         slug,
+		BookCount:    r.Hits.Total.Value,
+		InStockCount: &inStock.DocCount,
+		Languages:    buckets(languages),
+		Years:        buckets(years),
+	}
+
+	// Different spellings can share a slug ("Penguin Books" and "Penguin Books."), we
+	// show the most common one.
+	if len(name.Buckets) > 0 {
+		contributor.Name = name.Buckets[0].Key
+	}
+
+	return contributor, books, calculateMetadata(r.Hits.Total.Value, filters.Page, filters.PageSize), nil
+}
+
+// SuggestContributors returns up to limit authors or publishers (depending on kind)
+// whose name contains a word starting with prefix, the ones with the most books first.
+func (b BookModel) SuggestContributors(kind, prefix string, limit int) ([]*Contributor, error) {
+	fields, ok := contributorFields[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown contributor kind %q", kind)
+	}
+
+	query := fmt.Sprintf(`{
+		"size": 0,
+		"query": {
+			"match_bool_prefix": { %s: %s }
+		},
+		"aggs": {
+			"names": { "terms": { "field": %s, "size": %d } }
+		}
+	}`, quote(fields.text), quote(prefix), quote(fields.keyword), limit)
+
+	res, err := b.search(query)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	r, err := b.decodeElasticsearchResponse(res)
+	if err != nil {
+		return nil, err
+	}
+
+	var names esTermsAggregation
+	if err := r.aggregation("names", &names); err != nil {
+		return nil, err
+	}
+
+	contributors := []*Contributor{}
+	for _, bucket := range names.Buckets {
+		contributors = append(contributors, &Contributor{
+			Name:      bucket.Key,
+			Slug:      Slugify(bucket.Key),
+			BookCount: bucket.DocCount,
+		})
+	}
+
+	return contributors, nil
+}
+
+func buckets(agg esTermsAggregation) []Bucket {
+	result := []Bucket{}
+	for _, bucket := range agg.Buckets {
+		result = append(result, Bucket{Value: bucket.Key, Count: bucket.DocCount})
+	}
+	return result
+}
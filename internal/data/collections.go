@@ -0,0 +1,372 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+)
+
+var (
+	// ErrDuplicateSlug is returned when a collection slug is already taken.
+	ErrDuplicateSlug = errors.New("duplicate slug")
+	// ErrUnknownCoverBook is returned when the cover book of a collection doesn't exist.
+	ErrUnknownCoverBook = errors.New("unknown cover book")
+)
+
+// Collection is a curated shelf of books for the storefront, like "New Arrivals". It's
+// only shown between StartsAt and EndsAt (a nil time means no limit).
+type Collection struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Slug        string    `json:"slug"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	// CoverBookID is the book whose cover is the cover of the collection. CoverUrl
+	// points at the cover proxy for it and is only set for clients.
+	CoverBookID *int64     `json:"cover_book_id,omitempty"`
+	CoverUrl    string     `json:"coverurl,omitempty"`
+	Position    int        `json:"position"`
+	StartsAt    *time.Time `json:"starts_at,omitempty"`
+	EndsAt      *time.Time `json:"ends_at,omitempty"`
+	Version     int32      `json:"version"`
+}
+
+// Active reports whether the collection is within its schedule window at t.
+func (c *Collection) Active(t time.Time) bool {
+	if c.StartsAt != nil && t.Before(*c.StartsAt) {
+		return false
+	}
+	if c.EndsAt != nil && !t.Before(*c.EndsAt) {
+		return false
+	}
+	return true
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Slug != "", "slug", "must be provided")
+	v.Check(len(collection.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(Slugify(collection.Slug) == collection.Slug, "slug", "must only contain lower case letters, digits and dashes")
+
+	v.Check(collection.Title != "", "title", "must be provided")
+	v.Check(len(collection.Title) <= 200, "title", "must not be more than 200 bytes long")
+
+	v.Check(len(collection.Description) <= 5000, "description", "must not be more than 5000 bytes long")
+	if collection.CoverBookID != nil {
+		v.Check(*collection.CoverBookID > 0, "cover_book_id", "must be a positive integer")
+	}
+	v.Check(collection.Position >= 0, "position", "must not be negative")
+
+	if collection.StartsAt != nil && collection.EndsAt != nil {
+		v.Check(collection.EndsAt.After(*collection.StartsAt), "ends_at", "must be after starts_at")
+	}
+}
+
+// setCoverURL points CoverUrl at the cover proxy for the cover book, see
+// Book.RewriteCoverURL.
+func (c *Collection) setCoverURL() {
+	c.CoverUrl = ""
+	if c.CoverBookID != nil {
+		c.CoverUrl = fmt.Sprintf("/v1/books/detail/%d/cover", *c.CoverBookID)
+	}
+}
+
+// ValidateCollectionBooks checks the list of book ids of a collection.
+func ValidateCollectionBooks(v *validator.Validator, bookIDs []int64) {
+	v.Check(len(bookIDs) <= 500, "book_ids", "must not contain more than 500 books")
+
+	seen := make(map[int64]bool, len(bookIDs))
+	for _, id := range bookIDs {
+		v.Check(id > 0, "book_ids", "must only contain positive ids")
+		v.Check(!seen[id], "book_ids", "must not contain duplicate ids")
+		seen[id] = true
+	}
+}
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+const collectionColumns = `id, created_at, updated_at, slug, title, description, cover_book_id, position, starts_at, ends_at, version`
+
+func scanCollection(row interface{ Scan(...interface{}) error }, collection *Collection) error {
+	err := row.Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.UpdatedAt,
+		&collection.Slug,
+		&collection.Title,
+		&collection.Description,
+		&collection.CoverBookID,
+		&collection.Position,
+		&collection.StartsAt,
+		&collection.EndsAt,
+		&collection.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	collection.setCoverURL()
+	return nil
+}
+
+func (m CollectionModel) Insert(collection *Collection) error {
+	query := `
+		INSERT INTO collections (slug, title, description, cover_book_id, position, starts_at, ends_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{
+		collection.Slug,
+		collection.Title,
+		collection.Description,
+		collection.CoverBookID,
+		collection.Position,
+		collection.StartsAt,
+		collection.EndsAt,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return collectionError(err)
+	}
+
+	collection.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	collection.CreatedAt = time.Now()
+	collection.UpdatedAt = collection.CreatedAt
+	collection.Version = 1
+	collection.setCoverURL()
+
+	return nil
+}
+
+// GetBySlug returns the collection with the given slug, whether it's active or not.
+func (m CollectionModel) GetBySlug(slug string) (*Collection, error) {
+	query := `SELECT ` + collectionColumns + ` FROM collections WHERE slug = ?`
+
+	var collection Collection
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanCollection(m.DB.QueryRowContext(ctx, query, slug), &collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &collection, nil
+}
+
+// GetAll returns the collections in display order. Unless includeInactive is set, only
+// the collections within their schedule window are returned.
+func (m CollectionModel) GetAll(includeInactive bool) ([]*Collection, error) {
+	query := `
+		SELECT ` + collectionColumns + `
+		FROM collections
+		WHERE ? OR ((starts_at IS NULL OR starts_at <= CURRENT_TIMESTAMP) AND (ends_at IS NULL OR ends_at > CURRENT_TIMESTAMP))
+		ORDER BY position, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, includeInactive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []*Collection{}
+
+	for rows.Next() {
+		var collection Collection
+
+		if err := scanCollection(rows, &collection); err != nil {
+			return nil, err
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// Update saves a collection, using the version column for optimistic locking.
+func (m CollectionModel) Update(collection *Collection) error {
+	query := `
+		UPDATE collections
+		SET slug = ?, title = ?, description = ?, cover_book_id = ?, position = ?, starts_at = ?, ends_at = ?,
+			updated_at = CURRENT_TIMESTAMP, version = version + 1
+		WHERE id = ? AND version = ?`
+
+	args := []interface{}{
+		collection.Slug,
+		collection.Title,
+		collection.Description,
+		collection.CoverBookID,
+		collection.Position,
+		collection.StartsAt,
+		collection.EndsAt,
+		collection.ID,
+		collection.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return collectionError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	collection.UpdatedAt = time.Now()
+	collection.Version++
+	collection.setCoverURL()
+
+	return nil
+}
+
+func (m CollectionModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM collections WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// SetBooks replaces the books of a collection. The order of bookIDs is the display
+// order. Ids which don't match a book are reported with ErrRecordNotFound.
+func (m CollectionModel) SetBooks(collectionID int64, bookIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM collection_books WHERE collection_id = ?`, collectionID)
+	if err != nil {
+		return err
+	}
+
+	if len(bookIDs) > 0 {
+		args := make([]interface{}, 0, len(bookIDs)*3)
+		values := ""
+		for i, id := range bookIDs {
+			if i > 0 {
+				values += ", "
+			}
+			values += "(?, ?, ?)"
+			args = append(args, collectionID, id, i)
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO collection_books (collection_id, updated_edited_id, position) VALUES `+values, args...)
+		if err != nil {
+			// 1452 is a foreign key violation: one of the books doesn't exist.
+			var mysqlErr *mysql.MySQLError
+			if errors.As(err, &mysqlErr) && mysqlErr.Number == 1452 {
+				return fmt.Errorf("%w: unknown book id", ErrRecordNotFound)
+			}
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE collections SET updated_at = CURRENT_TIMESTAMP WHERE id = ?`, collectionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetBooks returns a page of the books of a collection in display order, with their
+// cover urls pointing at the cover proxy like search results.
+func (m CollectionModel) GetBooks(collectionID int64, filters Filters) ([]*Book, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var totalRecords int
+	err := m.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM collection_books WHERE collection_id = ?`, collectionID).Scan(&totalRecords)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := `
+		SELECT ue.id, ue.Title, ue.Author, ue.Coverurl, ue.Extension, ue.Year, ue.Publisher, ue.Language, ue.Identifier, ue.quantity, ue.price, ue.version, ue.rating_avg, ue.rating_count
+		FROM collection_books cb
+		INNER JOIN updated_edited ue ON ue.id = cb.updated_edited_id
+		WHERE cb.collection_id = ?
+		ORDER BY cb.position, ue.id
+		LIMIT ? OFFSET ?`
+
+	books, err := BookModel{DB: m.DB}.queryBooks(query, collectionID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	for _, book := range books {
+		book.RewriteCoverURL()
+	}
+
+	return books, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// collectionError converts a duplicate slug error into ErrDuplicateSlug and a missing
+// cover book into ErrUnknownCoverBook.
+func collectionError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1062:
+			return ErrDuplicateSlug
+		case 1452:
+			// The only foreign key of a collection is its cover book.
+			return ErrUnknownCoverBook
+		}
+	}
+	return err
+}
@@ -14,6 +14,10 @@ type Filters struct {
 	Page int
 	PageSize int
 	ISBN string
+	Highlight bool
+	// Sort is the requested order, it must be one of the values in SortSafelist.
+	Sort string
+	SortSafelist []string
 }
 
 
@@ -32,6 +36,9 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
+	// Check that the sort parameter matches a value in the safelist.
+	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
 }
 
 func ValidateAdvanceFilters(v *validator.Validator, f Filters) {
@@ -52,6 +59,15 @@ type Metadata struct {
 	FirstPage    int `json:"first_page,omitempty"`
 	LastPage     int `json:"last_page,omitempty"`
 	TotalRecords int `json:"total_records,omitempty"`
+	// DidYouMean is a spelling suggestion for the search, only set when the search
+	// returned very few results.
+	DidYouMean string `json:"did_you_mean,omitempty"`
+	// Degraded is set when Elasticsearch was unavailable and the results come from
+	// the simpler MySQL search instead.
+	Degraded bool `json:"degraded,omitempty"`
+	// SearchID identifies the search in the analytics, clients send it back when a
+	// result is clicked.
+	SearchID string `json:"search_id,omitempty"`
 }
 
 // The calculateMetadata() function calculates the appropriate pagination metadata
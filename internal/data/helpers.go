@@ -13,12 +13,85 @@ type esNativeResponse struct {
 			Value int
 		}
 		Hits []struct {
-			ID         string `json:"_id"`
-			Source     json.RawMessage `json:"_source"`
+			ID        string              `json:"_id"`
+			Source    json.RawMessage     `json:"_source"`
+			Highlight map[string][]string `json:"highlight"`
 		}
 	}
+	// Suggest holds the output of any named suggesters in the request, keyed by the
+	// name we gave them in the query (e.g. "did_you_mean").
+	Suggest map[string][]struct {
+		Text    string
+		Options []struct {
+			Text        string
+			Highlighted string
+			Score       float64
+		}
+	}
+	// Aggregations holds the raw result of every aggregation in the request, keyed by
+	// name. Their shape depends on the aggregation type, see esTermsAggregation.
+	Aggregations map[string]json.RawMessage
+}
+
+// esTermsAggregation is the result of a terms aggregation.
+type esTermsAggregation struct {
+	Buckets []struct {
+		Key      string
+		DocCount int `json:"doc_count"`
+	}
+}
+
+// esFilterAggregation is the result of a filter aggregation.
+type esFilterAggregation struct {
+	DocCount int `json:"doc_count"`
+}
+
+// aggregation decodes the named aggregation into dst. A missing aggregation leaves dst
+// untouched.
+func (r *esNativeResponse) aggregation(name string, dst interface{}) error {
+	raw, ok := r.Aggregations[name]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(raw, dst)
 }
 
 func buildQuery(query string) io.Reader {
 	return strings.NewReader(query)
-}
\ No newline at end of file
+}
+
+// quote returns s as a JSON string literal (including the surrounding double quotes),
+// so user input can be safely interpolated into the query templates.
+func quote(s string) string {
+	js, err := json.Marshal(s)
+	if err != nil {
+		return `""`
+	}
+	return string(js)
+}
+
+// placeholders returns n comma separated "?" placeholders for an IN (...) clause.
+func placeholders(n int) string {
+	if n < 1 {
+		return ""
+	}
+	return strings.Repeat("?, ", n-1) + "?"
+}
+
+// int64Args converts a slice of ids into query arguments.
+func int64Args(ids []int64) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+// stringArgs converts a slice of strings into query arguments.
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// OIDCLogin is a login in progress at an OpenID Connect provider.
+type OIDCLogin struct {
+	Provider string
+	Nonce    string
+	Verifier string
+}
+
+// IdentityModel links users to their accounts at OpenID Connect providers.
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// GetUser returns the user an identity is linked to.
+func (m IdentityModel) GetUser(provider, subject string) (*User, error) {
+	query := `
+		SELECT users.id, users.created_at, users.first_name, users.last_name, users.email, users.password_hash,
+			users.activated, users.pending_email, users.totp_enabled, users.version
+		FROM users
+		INNER JOIN user_identities ON user_identities.user_id = users.id
+		WHERE user_identities.provider = ? AND user_identities.subject = ?`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.PendingEmail,
+		&user.TwoFactor,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// Link links an identity to a user. Linking an identity to the user it's linked to
+// already does nothing.
+func (m IdentityModel) Link(userID int64, provider, subject, email string) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE user_id = user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, provider, subject, truncate(email, 255))
+	return err
+}
+
+// Touch records that the user logged in with an identity.
+func (m IdentityModel) Touch(provider, subject string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE user_identities SET last_login_at = ? WHERE provider = ? AND subject = ?`, time.Now(), provider, subject)
+	return err
+}
+
+// OIDCLoginModel keeps track of the logins in progress at OpenID Connect providers.
+type OIDCLoginModel struct {
+	DB *sql.DB
+}
+
+// Insert stores a login started with the given state by the client holding the state
+// verifier. Like tokens, only the hashes of the state and the state verifier are stored.
+func (m OIDCLoginModel) Insert(state, stateVerifier string, login *OIDCLogin, ttl time.Duration) error {
+	query := `
+		INSERT INTO oidc_logins (state_hash, state_verifier_hash, provider, nonce, verifier, expiry)
+		VALUES (?, ?, ?, ?, ?, ?)`
+
+	hash := sha256.Sum256([]byte(state))
+	verifierHash := sha256.Sum256([]byte(stateVerifier))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, hash[:], verifierHash[:], login.Provider, login.Nonce, login.Verifier, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+
+	// Forget the logins which were never finished.
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expiry < ?`, time.Now())
+	return err
+}
+
+// Take returns the login started with the given state and deletes it, so a state can
+// only be used once. ErrRecordNotFound is returned for unknown or expired states, and
+// when the state verifier isn't the one of the client which started the login: the
+// state is then someone else's, sneaked into the client to log it in to their account.
+// The login is left alone in that case, so whoever gets to see a state can't cancel
+// the login with it.
+func (m OIDCLoginModel) Take(state, stateVerifier string) (*OIDCLogin, error) {
+	hash := sha256.Sum256([]byte(state))
+	verifierHash := sha256.Sum256([]byte(stateVerifier))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var login OIDCLogin
+	var storedVerifierHash []byte
+	var expiry time.Time
+
+	query := `
+		SELECT state_verifier_hash, provider, nonce, verifier, expiry
+		FROM oidc_logins
+		WHERE state_hash = ?
+		FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, hash[:]).Scan(&storedVerifierHash, &login.Provider, &login.Nonce, &login.Verifier, &expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if subtle.ConstantTimeCompare(storedVerifierHash, verifierHash[:]) != 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM oidc_logins WHERE state_hash = ?`, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(expiry) {
+		return nil, ErrRecordNotFound
+	}
+
+	return &login, nil
+}
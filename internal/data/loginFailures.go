@@ -0,0 +1,187 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Kinds of login failure subjects. Two-factor failures are recorded per user id.
+const (
+	LoginFailureAccount   = "account"
+	LoginFailureIP        = "ip"
+	LoginFailureTwoFactor = "two_factor"
+)
+
+// LockoutPolicy describes how failed logins are throttled. Every failure after the
+// first FreeAttempts doubles the delay before the next attempt is allowed, starting at
+// BaseDelay and capped at MaxDelay. Threshold failures lock the subject out for
+// Lockout. Failures older than Window are forgotten.
+type LockoutPolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Threshold    int
+	Lockout      time.Duration
+	Window       time.Duration
+}
+
+// delay returns how long to wait after the given number of failures.
+func (p LockoutPolicy) delay(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// NormalizeLoginSubject returns the key failures of an account or IP are recorded under.
+func NormalizeLoginSubject(subject string) string {
+	return truncate(strings.ToLower(strings.TrimSpace(subject)), 255)
+}
+
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+// Reserve claims a login attempt for the subject under the policy. While the subject
+// has to wait because of earlier failures, it returns how long. Otherwise the attempt
+// is counted as a failure right away, before the password is even checked, so
+// concurrent attempts can't all get through before any of them is recorded; Release
+// takes it back when the attempt succeeds. locked reports whether this attempt locked
+// the subject out.
+func (m LoginFailureModel) Reserve(kind, subject string, policy LockoutPolicy) (wait time.Duration, locked bool, err error) {
+	subject = NormalizeLoginSubject(subject)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	var failures int
+	var lastFailedAt time.Time
+	var nextAttemptAt, lockedUntil sql.NullTime
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT failures, last_failed_at, next_attempt_at, locked_until
+		FROM login_failures
+		WHERE kind = ? AND subject = ?
+		FOR UPDATE`, kind, subject).Scan(&failures, &lastFailedAt, &nextAttemptAt, &lockedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+
+	now := time.Now()
+
+	for _, t := range []sql.NullTime{nextAttemptAt, lockedUntil} {
+		if t.Valid && t.Time.After(now) && t.Time.Sub(now) > wait {
+			wait = t.Time.Sub(now)
+		}
+	}
+
+	if wait > 0 {
+		return wait, false, nil
+	}
+
+	// Start counting again once the previous failures are old enough.
+	if now.Sub(lastFailedAt) > policy.Window {
+		failures = 0
+	}
+	failures++
+
+	var lockedUntilValue interface{}
+	locked = failures == policy.Threshold
+	if failures >= policy.Threshold {
+		lockedUntilValue = now.Add(policy.Lockout)
+	}
+
+	query := `
+		INSERT INTO login_failures (kind, subject, failures, last_failed_at, next_attempt_at, locked_until)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE failures = VALUES(failures), last_failed_at = VALUES(last_failed_at),
+			next_attempt_at = VALUES(next_attempt_at), locked_until = VALUES(locked_until)`
+
+	_, err = tx.ExecContext(ctx, query, kind, subject, failures, now, now.Add(policy.delay(failures)), lockedUntilValue)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return 0, locked, tx.Commit()
+}
+
+// Release takes back an attempt claimed with Reserve which turned out to be a
+// successful login, lifting the delay and the lockout it added.
+func (m LoginFailureModel) Release(kind, subject string, policy LockoutPolicy) error {
+	subject = NormalizeLoginSubject(subject)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var failures int
+	var lastFailedAt time.Time
+	var lockedUntil sql.NullTime
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT failures, last_failed_at, locked_until
+		FROM login_failures
+		WHERE kind = ? AND subject = ?
+		FOR UPDATE`, kind, subject).Scan(&failures, &lastFailedAt, &lockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	if failures > 0 {
+		failures--
+	}
+
+	// Only a lockout this attempt caused is lifted.
+	if failures < policy.Threshold {
+		lockedUntil = sql.NullTime{}
+	}
+
+	query := `
+		UPDATE login_failures
+		SET failures = ?, next_attempt_at = ?, locked_until = ?
+		WHERE kind = ? AND subject = ?`
+
+	_, err = tx.ExecContext(ctx, query, failures, lastFailedAt.Add(policy.delay(failures)), lockedUntil, kind, subject)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Reset forgets the failed logins of a subject, which also lifts a lockout.
+func (m LoginFailureModel) Reset(kind, subject string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM login_failures WHERE kind = ? AND subject = ?`, kind, NormalizeLoginSubject(subject))
+	return err
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v7"
 )
@@ -14,29 +15,66 @@ var (
 	ErrEditConflict         = errors.New("edit conflict")
 	ErrNotEnoughStock       = errors.New("not enough stock")
 	ErrQuantityBelowMinimum = errors.New("the new quantity is below 0")
+	ErrSearchUnavailable    = errors.New("search unavailable")
 )
 
 type Models struct {
 	Books interface {
 		GetAll(filters Filters) ([]*Book, Metadata, error)
-		GetBookSuggestions(typeSearch string, filters Filters) ([]*Book, error)
+		GetBookSuggestions(typeSearch string, filters Filters, limit int) (*Suggestions, error)
 		AdvanceFilterBooks(filters Filters) ([]*Book, Metadata, error)
 		GetBook(id int64) (*Book, error)
+		Insert(book *Book) error
+		Update(book *Book) error
+		Delete(id int64) error
+		Upsert(book *Book) (bool, error)
+		FindIDByIdentifier(identifier string) (int64, error)
+		GetBooks(ids []int64) ([]*Book, error)
+		ListAfter(afterID int64, limit int) ([]*Book, error)
+		Related(id int64, limit int) ([]*Book, bool, error)
+		AlsoBought(id int64, limit int) ([]*Book, error)
+		RefreshAlsoBought() error
+		GetContributor(kind, slug string, filters Filters) (*Contributor, []*Book, Metadata, error)
+		SuggestContributors(kind, prefix string, limit int) ([]*Contributor, error)
+		Analyze(text string, index bool) ([]AnalyzedToken, error)
+		BulkIndex(books []*Book, version int64) error
+		BulkSync(books []*Book, deletedIDs []int64, versions map[int64]int64) error
+		BulkSyncIndex(index string, books []*Book, deletedIDs []int64, versions map[int64]int64) error
 	}
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
-	Indonesia   IndonesiaModel
-	Carts       CartModel
+	Users         UserModel
+	Tokens        TokenModel
+	Permissions   PermissionModel
+	Roles         RoleModel
+	Indonesia     IndonesiaModel
+	Carts         CartModel
+	Outbox        OutboxModel
+	Reviews       ReviewModel
+	Collections   CollectionModel
+	Analytics     AnalyticsModel
+	SearchTerms   SearchTermModel
+	TwoFactor     TwoFactorModel
+	LoginFailures LoginFailureModel
+	Identities    IdentityModel
+	OIDCLogins    OIDCLoginModel
 }
 
-func NewModel(db *sql.DB, es *elasticsearch.Client) Models {
+func NewModel(db *sql.DB, es *elasticsearch.Client, esIndex string) Models {
 	return Models{
-		Books:       BookModel{DB: db, ES: es},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Indonesia:   IndonesiaModel{DB: db},
-		Carts:       CartModel{DB: db},
+		Books:         BookModel{DB: db, ES: es, Index: esIndex, breaker: newCircuitBreaker(5, 30*time.Second)},
+		Users:         UserModel{DB: db},
+		Tokens:        TokenModel{DB: db},
+		Permissions:   PermissionModel{DB: db},
+		Roles:         RoleModel{DB: db},
+		Indonesia:     IndonesiaModel{DB: db},
+		Carts:         CartModel{DB: db},
+		Outbox:        OutboxModel{DB: db},
+		Reviews:       ReviewModel{DB: db},
+		Collections:   CollectionModel{DB: db},
+		Analytics:     AnalyticsModel{DB: db},
+		SearchTerms:   SearchTermModel{DB: db},
+		TwoFactor:     TwoFactorModel{DB: db},
+		LoginFailures: LoginFailureModel{DB: db},
+		Identities:    IdentityModel{DB: db},
+		OIDCLogins:    OIDCLoginModel{DB: db},
 	}
 }
@@ -0,0 +1,158 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// OutboxEntry records that a book changed and its search document has to be
+// refreshed. The entries are written by triggers on the updated_edited table.
+type OutboxEntry struct {
+	ID        int64
+	CreatedAt time.Time
+	BookID    int64
+	Attempts  int
+}
+
+// Define the OutboxModel type.
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+// Pending returns up to limit entries which are due for processing, oldest first. The
+// entries aren't claimed, several synchronizers may get the same ones; the document
+// versions keep that safe, see BookModel.BulkSync.
+func (m OutboxModel) Pending(limit int) ([]*OutboxEntry, error) {
+	query := `
+		SELECT id, created_at, updated_edited_id, attempts
+		FROM books_outbox
+		WHERE available_at <= NOW()
+		ORDER BY id
+		LIMIT ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*OutboxEntry
+
+	for rows.Next() {
+		var entry OutboxEntry
+
+		err := rows.Scan(&entry.ID, &entry.CreatedAt, &entry.BookID, &entry.Attempts)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Delete removes entries once they have been processed.
+func (m OutboxModel) Delete(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `
+		DELETE FROM books_outbox
+		WHERE id IN (` + placeholders(len(ids)) + `)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, int64Args(ids)...)
+	return err
+}
+
+// Retry records a failed attempt for the entries and postpones them by the given
+// delay.
+func (m OutboxModel) Retry(ids []int64, delay time.Duration, lastError string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// Keep the error message within the size of the column.
+	if len(lastError) > 1000 {
+		lastError = lastError[:1000]
+	}
+
+	query := `
+		UPDATE books_outbox
+		SET attempts = attempts + 1, available_at = NOW() + INTERVAL ? SECOND, last_error = ?
+		WHERE id IN (` + placeholders(len(ids)) + `)`
+
+	args := append([]interface{}{int(delay.Seconds()), lastError}, int64Args(ids)...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// maxBuildAge is how long an index stays registered as being built. It keeps the
+// synchronizers from writing to an index forever if a rebuild died without
+// unregistering it.
+const maxBuildAge = 24 * time.Hour
+
+// StartBuild registers an index which is being loaded by a rebuild, so the
+// synchronizers write every change to it as well. The failed builds recorded so far
+// are forgotten.
+func (m OutboxModel) StartBuild(index string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM search_index_builds WHERE failed_at IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `INSERT INTO search_index_builds (index_name) VALUES (?)`, index)
+	return err
+}
+
+// FinishBuild unregisters an index once it's live.
+func (m OutboxModel) FinishBuild(index string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM search_index_builds WHERE index_name = ?`, index)
+	return err
+}
+
+// FailBuild marks the build of an index as failed or cancelled, so it no longer counts
+// as running and the synchronizers stop writing to it.
+func (m OutboxModel) FailBuild(index string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE search_index_builds SET failed_at = ? WHERE index_name = ?`, time.Now(), index)
+	return err
+}
+
+// Builds returns the indices which are being built.
+func (m OutboxModel) Builds() ([]string, error) {
+	query := `
+		SELECT index_name
+		FROM search_index_builds
+		WHERE created_at > ? AND failed_at IS NULL
+		ORDER BY index_name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return queryCodes(ctx, m.DB, query, time.Now().Add(-maxBuildAge))
+}
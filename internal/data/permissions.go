@@ -3,10 +3,13 @@ package data
 import (
 	"context"
 	"database/sql"
-	"fmt"
+	"errors"
 	"time"
 )
 
+// ErrUnknownPermission is returned when granting a permission which doesn't exist.
+var ErrUnknownPermission = errors.New("unknown permission")
+
 // Define a Permissions slice, which we will use to hold the permission codes
 // (like "books:read" and "books:write") for a single user.
 type Permissions []string
@@ -28,19 +31,25 @@ type PermissionModel struct {
 }
 
 // The GetAllForUser() method returns all permission codes for a specific user in a
-// Permissions slice.
+// Permissions slice: the ones granted to the user directly, and the ones of their roles.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
 		FROM permissions
 		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-		INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = ?`
+		WHERE users_permissions.user_id = ?
+		UNION
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN role_permissions ON role_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = role_permissions.role_id
+		WHERE users_roles.user_id = ?
+		ORDER BY code`
 	
 	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+	rows, err := m.DB.QueryContext(ctx, query, userID, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -68,25 +77,117 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 
 // Add the provided permission codes for a specific user. notice that we're using a
 // variadic parameter for the codes so that we can assign multiple permissions in a
-// single call
+// single call. Permissions the user has already are skipped, and ErrUnknownPermission
+// is returned (without granting anything) if any of the codes doesn't exist.
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
-	
-	var args string
-	codesLen := len(codes)
-	for i := 0; i < codesLen; i++ {
-		args += fmt.Sprintf(`"%s"`, codes[i]) 
-		if i != (codesLen - 1) {
-			args += ","
-		}
+	if len(codes) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	defer cancel()
+
+	known, err := countCodes(ctx, m.DB, "permissions", codes)
+	if err != nil {
+		return err
+	}
+
+	if !known {
+		return ErrUnknownPermission
+	}
+
+	query := `
+		INSERT IGNORE INTO users_permissions (user_id, permission_id)
+		SELECT ?, permissions.id FROM permissions WHERE permissions.code IN (` + placeholders(len(codes)) + `)`
+
+	args := append([]interface{}{userID}, stringArgs(codes)...)
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// RemoveForUser revokes permissions granted to a user directly. Permissions the user
+// gets from a role aren't affected, see RoleModel.RemoveForUser.
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	if len(codes) == 0 {
+		return nil
 	}
 
-	query := fmt.Sprintf(`
-	INSERT INTO users_permissions
-	SELECT ?, permissions.id FROM permissions WHERE permissions.code IN (%s)`, args)
+	query := `
+		DELETE users_permissions
+		FROM users_permissions
+		INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+		WHERE users_permissions.user_id = ? AND permissions.code IN (` + placeholders(len(codes)) + `)`
+
+	args := append([]interface{}{userID}, stringArgs(codes)...)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID)
+	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
-}
\ No newline at end of file
+}
+
+// GetDirectForUser returns the permission codes granted to a user directly, without
+// the ones of their roles.
+func (m PermissionModel) GetDirectForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = ?
+		ORDER BY permissions.code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	defer cancel()
+
+	codes, err := queryCodes(ctx, m.DB, query, userID)
+	return Permissions(codes), err
+}
+
+// countCodes reports whether all the codes exist in table (permissions or roles).
+func countCodes(ctx context.Context, db *sql.DB, table string, codes []string) (bool, error) {
+	unique := make(map[string]bool)
+	for _, code := range codes {
+		unique[code] = true
+	}
+
+	query := `SELECT COUNT(DISTINCT code) FROM ` + table + ` WHERE code IN (` + placeholders(len(codes)) + `)`
+
+	var count int
+
+	err := db.QueryRowContext(ctx, query, stringArgs(codes)...).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count == len(unique), nil
+}
+
+// queryCodes runs a query selecting a single code column.
+func queryCodes(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := []string{}
+
+	for rows.Next() {
+		var code string
+
+		err := rows.Scan(&code)
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
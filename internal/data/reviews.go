@@ -0,0 +1,309 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+)
+
+var (
+	// ErrNotPurchased is returned when a user reviews a book they never ordered.
+	ErrNotPurchased = errors.New("book not purchased")
+	// ErrDuplicateReview is returned when a user reviews the same book twice.
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+// Review is a rating (1 to 5) and a short text a customer left on a book they bought.
+// Hidden reviews were taken down by a moderator, they're only shown to moderators and
+// don't count towards the rating of the book.
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    int64     `json:"user_id"`
+	// Reviewer is the first name of the user, for display.
+	Reviewer string `json:"reviewer"`
+	BookID   int64  `json:"book_id"`
+	Rating   int    `json:"rating"`
+	Body     string `json:"body"`
+	Hidden   bool   `json:"hidden,omitempty"`
+	Version  int32  `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "must be between 1 and 5")
+	v.Check(strings.TrimSpace(review.Body) != "", "body", "must be provided")
+	v.Check(len(review.Body) <= 5000, "body", "must not be more than 5000 bytes long")
+}
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a review. The user must have ordered the book: the review is tied to the
+// most recent order item of the book, otherwise ErrNotPurchased is returned.
+func (m ReviewModel) Insert(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var orderItemID int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT order_items.id
+		FROM order_items
+		INNER JOIN orders ON orders.id = order_items.order_id
+		WHERE orders.user_id = ? AND order_items.updated_edited_id = ?
+		ORDER BY order_items.id DESC
+		LIMIT 1`, review.UserID, review.BookID).Scan(&orderItemID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotPurchased
+		default:
+			return err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO reviews (user_id, updated_edited_id, order_item_id, rating, body)
+		VALUES (?, ?, ?, ?, ?)`,
+		review.UserID, review.BookID, orderItemID, review.Rating, review.Body)
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			return ErrDuplicateReview
+		}
+		return err
+	}
+
+	review.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	err = refreshRating(ctx, tx, review.BookID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	review.CreatedAt = time.Now()
+	review.UpdatedAt = review.CreatedAt
+	review.Version = 1
+
+	return nil
+}
+
+// Get returns a single review, hidden or not.
+func (m ReviewModel) Get(id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT reviews.id, reviews.created_at, reviews.updated_at, reviews.user_id, users.first_name,
+			reviews.updated_edited_id, reviews.rating, reviews.body, reviews.hidden, reviews.version
+		FROM reviews
+		INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.id = ?`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.UserID,
+		&review.Reviewer,
+		&review.BookID,
+		&review.Rating,
+		&review.Body,
+		&review.Hidden,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// Update saves the rating, body and hidden flag of a review, using the version column
+// for optimistic locking like BookModel.Update.
+func (m ReviewModel) Update(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE reviews
+		SET rating = ?, body = ?, hidden = ?, updated_at = CURRENT_TIMESTAMP, version = version + 1
+		WHERE id = ? AND version = ?`,
+		review.Rating, review.Body, review.Hidden, review.ID, review.Version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	err = refreshRating(ctx, tx, review.BookID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	review.UpdatedAt = time.Now()
+	review.Version++
+
+	return nil
+}
+
+// Delete removes a review and updates the rating of its book.
+func (m ReviewModel) Delete(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM reviews WHERE id = ?`, review.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = refreshRating(ctx, tx, review.BookID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAllForBook returns a page of the reviews of a book. Hidden reviews are left out
+// unless includeHidden is set. The sort values are "-created_at" (newest first),
+// "created_at", "-rating" and "rating".
+func (m ReviewModel) GetAllForBook(bookID int64, includeHidden bool, filters Filters) ([]*Review, Metadata, error) {
+	orderBy := "reviews.created_at DESC"
+	switch filters.Sort {
+	case "created_at":
+		orderBy = "reviews.created_at ASC"
+	case "-rating":
+		orderBy = "reviews.rating DESC, reviews.created_at DESC"
+	case "rating":
+		orderBy = "reviews.rating ASC, reviews.created_at DESC"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), reviews.id, reviews.created_at, reviews.updated_at, reviews.user_id, users.first_name,
+			reviews.updated_edited_id, reviews.rating, reviews.body, reviews.hidden, reviews.version
+		FROM reviews
+		INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.updated_edited_id = ? AND (reviews.hidden = FALSE OR ?)
+		ORDER BY %s, reviews.id DESC
+		LIMIT ? OFFSET ?`, orderBy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, bookID, includeHidden, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.UserID,
+			&review.Reviewer,
+			&review.BookID,
+			&review.Rating,
+			&review.Body,
+			&review.Hidden,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return reviews, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// refreshRating recomputes the rating summary of a book from its visible reviews. The
+// update goes through the books outbox trigger, so the new rating also reaches the
+// search index.
+func refreshRating(ctx context.Context, tx *sql.Tx, bookID int64) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE updated_edited
+		SET
+			rating_avg = (SELECT COALESCE(AVG(rating), 0) FROM reviews WHERE updated_edited_id = ? AND hidden = FALSE),
+			rating_count = (SELECT COUNT(*) FROM reviews WHERE updated_edited_id = ? AND hidden = FALSE)
+		WHERE id = ?`, bookID, bookID, bookID)
+	return err
+}
@@ -0,0 +1,89 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// The roles created by the migrations. New users are customers.
+const (
+	RoleCustomer = "customer"
+	RoleStaff    = "staff"
+	RoleAdmin    = "admin"
+)
+
+// ErrUnknownRole is returned when assigning a role which doesn't exist.
+var ErrUnknownRole = errors.New("unknown role")
+
+// RoleModel assigns roles, named sets of permissions, to users.
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// GetAllForUser returns the codes of the roles of a user.
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+		SELECT roles.code
+		FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = ?
+		ORDER BY roles.code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return queryCodes(ctx, m.DB, query, userID)
+}
+
+// AddForUser assigns roles to a user. Roles the user has already are skipped, and
+// ErrUnknownRole is returned (without assigning anything) if any of the codes doesn't
+// exist.
+func (m RoleModel) AddForUser(userID int64, codes ...string) error {
+	if len(codes) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	known, err := countCodes(ctx, m.DB, "roles", codes)
+	if err != nil {
+		return err
+	}
+
+	if !known {
+		return ErrUnknownRole
+	}
+
+	query := `
+		INSERT IGNORE INTO users_roles (user_id, role_id)
+		SELECT ?, roles.id FROM roles WHERE roles.code IN (` + placeholders(len(codes)) + `)`
+
+	args := append([]interface{}{userID}, stringArgs(codes)...)
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// RemoveForUser takes roles away from a user.
+func (m RoleModel) RemoveForUser(userID int64, codes ...string) error {
+	if len(codes) == 0 {
+		return nil
+	}
+
+	query := `
+		DELETE users_roles
+		FROM users_roles
+		INNER JOIN roles ON roles.id = users_roles.role_id
+		WHERE users_roles.user_id = ? AND roles.code IN (` + placeholders(len(codes)) + `)`
+
+	args := append([]interface{}{userID}, stringArgs(codes)...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
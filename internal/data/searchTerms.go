@@ -0,0 +1,228 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
+)
+
+// ErrDuplicateStopword is returned when a stopword is already in the list.
+var ErrDuplicateStopword = errors.New("duplicate stopword")
+
+// Synonym is a synonym rule in the Solr format: a comma separated list of equivalent
+// terms ("js, javascript") or an explicit mapping ("ebook, e book => e-book").
+type Synonym struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Rule      string    `json:"rule"`
+}
+
+// Stopword is a word left out of search queries.
+type Stopword struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Word      string    `json:"word"`
+}
+
+func ValidateSynonym(v *validator.Validator, synonym *Synonym) {
+	v.Check(synonym.Rule != "", "rule", "must be provided")
+	v.Check(len(synonym.Rule) <= 500, "rule", "must not be more than 500 bytes long")
+	v.Check(!strings.ContainsAny(synonym.Rule, "\r\n"), "rule", "must be a single line")
+
+	sides := strings.Split(synonym.Rule, "=>")
+	v.Check(len(sides) <= 2, "rule", "must contain at most one =>")
+
+	terms := 0
+	for _, side := range sides {
+		for _, term := range strings.Split(side, ",") {
+			v.Check(strings.TrimSpace(term) != "", "rule", "must not contain empty terms")
+			terms++
+		}
+	}
+
+	// A plain list needs at least two terms to mean anything.
+	if len(sides) == 1 {
+		v.Check(terms >= 2, "rule", "must contain at least two terms")
+	}
+}
+
+func ValidateStopword(v *validator.Validator, stopword *Stopword) {
+	v.Check(stopword.Word != "", "word", "must be provided")
+	v.Check(len(stopword.Word) <= 100, "word", "must not be more than 100 bytes long")
+	v.Check(len(strings.Fields(stopword.Word)) == 1, "word", "must be a single word")
+}
+
+// SearchTermModel stores the synonyms and stopwords of the search analyzer. Changes
+// only reach Elasticsearch when the index is rebuilt, by cmd/reindex or when they're
+// applied through the API.
+type SearchTermModel struct {
+	DB *sql.DB
+}
+
+func (m SearchTermModel) GetSynonyms() ([]*Synonym, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, created_at, rule FROM search_synonyms ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	synonyms := []*Synonym{}
+
+	for rows.Next() {
+		var synonym Synonym
+
+		if err := rows.Scan(&synonym.ID, &synonym.CreatedAt, &synonym.Rule); err != nil {
+			return nil, err
+		}
+
+		synonyms = append(synonyms, &synonym)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return synonyms, nil
+}
+
+func (m SearchTermModel) InsertSynonym(synonym *Synonym) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `INSERT INTO search_synonyms (rule) VALUES (?)`, synonym.Rule)
+	if err != nil {
+		return err
+	}
+
+	synonym.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	synonym.CreatedAt = time.Now()
+
+	return nil
+}
+
+func (m SearchTermModel) DeleteSynonym(id int64) error {
+	return m.delete(`DELETE FROM search_synonyms WHERE id = ?`, id)
+}
+
+func (m SearchTermModel) GetStopwords() ([]*Stopword, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, created_at, word FROM search_stopwords ORDER BY word`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stopwords := []*Stopword{}
+
+	for rows.Next() {
+		var stopword Stopword
+
+		if err := rows.Scan(&stopword.ID, &stopword.CreatedAt, &stopword.Word); err != nil {
+			return nil, err
+		}
+
+		stopwords = append(stopwords, &stopword)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stopwords, nil
+}
+
+// InsertStopword adds a stopword. Words are stored in lower case, like the analyzer
+// sees them.
+func (m SearchTermModel) InsertStopword(stopword *Stopword) error {
+	stopword.Word = strings.ToLower(stopword.Word)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `INSERT INTO search_stopwords (word) VALUES (?)`, stopword.Word)
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			return ErrDuplicateStopword
+		}
+		return err
+	}
+
+	stopword.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	stopword.CreatedAt = time.Now()
+
+	return nil
+}
+
+func (m SearchTermModel) DeleteStopword(id int64) error {
+	return m.delete(`DELETE FROM search_stopwords WHERE id = ?`, id)
+}
+
+// Analysis returns every synonym and stopword, ready to be rendered into the index
+// settings.
+func (m SearchTermModel) Analysis() (SearchAnalysis, error) {
+	var analysis SearchAnalysis
+
+	synonyms, err := m.GetSynonyms()
+	if err != nil {
+		return analysis, err
+	}
+
+	for _, synonym := range synonyms {
+		analysis.Synonyms = append(analysis.Synonyms, synonym.Rule)
+	}
+
+	stopwords, err := m.GetStopwords()
+	if err != nil {
+		return analysis, err
+	}
+
+	for _, stopword := range stopwords {
+		analysis.Stopwords = append(analysis.Stopwords, stopword.Word)
+	}
+
+	return analysis, nil
+}
+
+func (m SearchTermModel) delete(query string, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,27 @@
+package data
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Slugify turns a name into the form used in URLs: lower case letters and digits
+// separated by single dashes, e.g. "Brian W. Kernighan" becomes "brian-w-kernighan".
+func Slugify(name string) string {
+	var sb strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+
+	return sb.String()
+}
@@ -2,10 +2,12 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
-	"math/rand"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"github.com/hafizmfadli/hello-nerds-api/internal/validator"
@@ -15,8 +17,16 @@ import (
 const (
 	ScopeActivation = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset = "password-reset"
+	ScopeEmailChange = "email-change"
+	ScopeRefresh = "refresh"
+	ScopeTwoFactor = "two-factor"
 )
 
+// ErrTokenReused is returned when a refresh token which was exchanged already is
+// presented again.
+var ErrTokenReused = errors.New("refresh token reused")
+
 // Define a Token struct to hold the data for an individual token
 type Token struct {
 	Plaintext string    `json:"token"`
@@ -24,6 +34,24 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	// UserAgent and IP describe the client an authentication token was issued to.
+	UserAgent string `json:"-"`
+	IP        string `json:"-"`
+	// Family links the access and refresh tokens issued at a login, and the ones which
+	// replaced them.
+	Family string `json:"-"`
+}
+
+// Session is an authentication token as shown to its owner. Current is set for the
+// token the request was made with.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	Current    bool       `json:"current"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -91,13 +119,150 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewSession logs a user in: it creates a short-lived access token (the scope
+// `authentication`) and a long-lived refresh token in a new token family, for the client
+// described by userAgent and ip. With an accessTTL of zero only the refresh token is
+// created and the returned access token is nil, for when access tokens are signed
+// tokens which aren't stored.
+func (m TokenModel) NewSession(userID int64, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	familyBytes := make([]byte, 16)
+	if _, err := rand.Read(familyBytes); err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	access, refresh, err := insertTokenPair(ctx, tx, userID, hex.EncodeToString(familyBytes), accessTTL, refreshTTL, userAgent, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token in the same
+// family. A refresh token can only be used once: the access tokens issued before it are
+// revoked, and if it is ever presented again the whole family is revoked and
+// ErrTokenReused is returned, since either the legitimate client or an attacker is
+// holding a copy. The reused refresh token is returned with the error, with only its
+// UserID, Scope and Family set, so the caller can revoke what else was issued to the
+// session. Like NewSession, an accessTTL of zero only creates the refresh token.
+// Refresh tokens carry the user id and the family, for issuing a signed access token.
+func (m TokenModel) Refresh(refreshPlaintext string, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	refreshHash := sha256.Sum256([]byte(refreshPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var family string
+	var usedAt sql.NullTime
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT user_id, family, used_at
+		FROM tokens
+		WHERE hash = ? AND scope = ? AND expiry > ?
+		FOR UPDATE`,
+		refreshHash[:], ScopeRefresh, time.Now()).Scan(&userID, &family, &usedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if usedAt.Valid {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = ?`, family)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return nil, nil, err
+		}
+
+		return nil, &Token{UserID: userID, Scope: ScopeRefresh, Family: family}, ErrTokenReused
+	}
+
+	// The used refresh token is kept (until it expires) so reuse can be detected.
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = ? WHERE hash = ?`, time.Now(), refreshHash[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = ? AND scope = ?`, family, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := insertTokenPair(ctx, tx, userID, family, accessTTL, refreshTTL, userAgent, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+func insertTokenPair(ctx context.Context, tx *sql.Tx, userID int64, family string, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tokens := []*Token{refresh}
+
+	var access *Token
+	if accessTTL > 0 {
+		access, err = generateToken(userID, accessTTL, ScopeAuthentication)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tokens = append(tokens, access)
+	}
+
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, ip, family)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	for _, token := range tokens {
+		token.UserAgent = truncate(userAgent, 255)
+		token.IP = ip
+		token.Family = family
+
+		args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IP, token.Family}
+
+		_, err = tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, nil
+}
+
 // Insert() adds the data for a specific token to the tokens table
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES (?, ?, ?, ?)`
+		INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, ip)
+		VALUES (?, ?, ?, ?, ?, ?)`
 	
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IP}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
 	defer cancel()
@@ -119,3 +284,273 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	return err
 }
 
+
+// Delete revokes a token. When the token belongs to a family, the rest of the family
+// is revoked with it, so logging out also invalidates the refresh token.
+func (m TokenModel) Delete(tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var family string
+
+	err := m.DB.QueryRowContext(ctx, `SELECT family FROM tokens WHERE hash = ?`, tokenHash[:]).Scan(&family)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	if family != "" {
+		_, err = m.DB.ExecContext(ctx, `DELETE FROM tokens WHERE family = ?`, family)
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM tokens WHERE hash = ?`, tokenHash[:])
+	return err
+}
+
+// DeleteFamily revokes all tokens of a token family.
+func (m TokenModel) DeleteFamily(family string) error {
+	if family == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM tokens WHERE family = ?`, family)
+	return err
+}
+
+// DeleteOtherSessions logs a user out of every session except the one of the request,
+// deleting their other authentication and refresh tokens. currentPlaintext and
+// currentFamily identify the current session like in GetSessions.
+func (m TokenModel) DeleteOtherSessions(userID int64, currentPlaintext, currentFamily string) error {
+	currentHash := sha256.Sum256([]byte(currentPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if currentFamily == "" {
+		err := m.DB.QueryRowContext(ctx, `SELECT family FROM tokens WHERE hash = ?`, currentHash[:]).Scan(&currentFamily)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = ? AND scope IN (?, ?) AND hash <> ? AND (family = '' OR family <> ?)`
+
+	args := []interface{}{userID, ScopeAuthentication, ScopeRefresh, currentHash[:], currentFamily}
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Touch records that a token was just used by the client described by userAgent and
+// ip. To spare the database a write on every request, last_used_at is only updated
+// once a minute.
+func (m TokenModel) Touch(tokenPlaintext, userAgent, ip string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	now := time.Now()
+
+	query := `
+		UPDATE tokens
+		SET last_used_at = ?, user_agent = ?, ip = ?
+		WHERE hash = ? AND (last_used_at IS NULL OR last_used_at < ?)`
+
+	args := []interface{}{now, truncate(userAgent, 255), ip, tokenHash[:], now.Add(-time.Minute)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetSessions returns the sessions of a user, most recently used first. A session is
+// the current refresh token of a token family, or an authentication token issued
+// outside of a family. currentPlaintext is the token of the request and currentFamily
+// its family (when the token isn't stored, like signed tokens); the matching session is
+// flagged as the current one.
+func (m TokenModel) GetSessions(userID int64, currentPlaintext, currentFamily string) ([]*Session, error) {
+	currentHash := sha256.Sum256([]byte(currentPlaintext))
+
+	// The client details of a family are read from its access token, which is the one
+	// that gets touched on every request.
+	query := `
+		SELECT t.id, (t.hash = ? OR (t.family <> '' AND (t.family = c.family OR t.family = ?))), t.created_at,
+			COALESCE(a.last_used_at, t.last_used_at), t.expiry, COALESCE(a.user_agent, t.user_agent), COALESCE(a.ip, t.ip)
+		FROM tokens t
+		LEFT JOIN tokens a ON t.family <> '' AND a.family = t.family AND a.scope = ?
+		LEFT JOIN tokens c ON c.hash = ?
+		WHERE t.user_id = ? AND t.expiry > ?
+		AND ((t.scope = ? AND t.family = '') OR (t.scope = ? AND t.used_at IS NULL))
+		ORDER BY COALESCE(a.last_used_at, t.last_used_at, t.created_at) DESC, t.id DESC`
+
+	args := []interface{}{currentHash[:], currentFamily, ScopeAuthentication, currentHash[:], userID, time.Now(), ScopeAuthentication, ScopeRefresh}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+		var lastUsedAt sql.NullTime
+
+		err := rows.Scan(
+			&session.ID,
+			&session.Current,
+			&session.CreatedAt,
+			&lastUsedAt,
+			&session.Expiry,
+			&session.UserAgent,
+			&session.IP,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if lastUsedAt.Valid {
+			session.LastUsedAt = &lastUsedAt.Time
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Revoke puts the id of a signed token on the revocation list until it expires.
+func (m TokenModel) Revoke(jti string, expiry time.Time) error {
+	query := `
+		INSERT INTO revoked_tokens (jti, expiry)
+		VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE expiry = VALUES(expiry)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, jti, expiry)
+	return err
+}
+
+// GetRevoked returns the unexpired entries of the revocation list, mapping token ids
+// to their expiry. Expired entries are deleted on the way.
+func (m TokenModel) GetRevoked() (map[string]time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expiry <= ?`, now)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT jti, expiry FROM revoked_tokens WHERE expiry > ?`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+
+	for rows.Next() {
+		var jti string
+		var expiry time.Time
+
+		if err := rows.Scan(&jti, &expiry); err != nil {
+			return nil, err
+		}
+
+		revoked[jti] = expiry
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revoked, nil
+}
+
+// RevokedUser is an entry of the per-user revocation list: the signed tokens of the
+// user issued before Before are revoked. The entry is kept until Expiry, when the last
+// of those tokens has expired.
+type RevokedUser struct {
+	Before time.Time
+	Expiry time.Time
+}
+
+// RevokeUser revokes every signed token of a user issued before revoked.Before. An
+// earlier entry of the user is only ever moved forward.
+func (m TokenModel) RevokeUser(userID int64, revoked RevokedUser) error {
+	query := `
+		INSERT INTO revoked_users (user_id, revoked_before, expiry)
+		VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			revoked_before = GREATEST(revoked_before, VALUES(revoked_before)),
+			expiry = GREATEST(expiry, VALUES(expiry))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, revoked.Before, revoked.Expiry)
+	return err
+}
+
+// GetRevokedUsers returns the unexpired entries of the per-user revocation list, by
+// user id. Expired entries are deleted on the way.
+func (m TokenModel) GetRevokedUsers() (map[int64]RevokedUser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM revoked_users WHERE expiry <= ?`, now)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT user_id, revoked_before, expiry FROM revoked_users WHERE expiry > ?`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := make(map[int64]RevokedUser)
+
+	for rows.Next() {
+		var userID int64
+		var entry RevokedUser
+
+		if err := rows.Scan(&userID, &entry.Before, &entry.Expiry); err != nil {
+			return nil, err
+		}
+
+		revoked[userID] = entry
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/totp"
+)
+
+// RecoveryCodeCount is the number of recovery codes a user gets when enabling
+// two-factor authentication.
+const RecoveryCodeCount = 10
+
+var (
+	// ErrTwoFactorNotEnrolled is returned when confirming two-factor authentication for
+	// a user who hasn't started enrolling.
+	ErrTwoFactorNotEnrolled = errors.New("two-factor authentication not enrolled")
+	// ErrTwoFactorEnabled is returned when confirming two-factor authentication for a
+	// user who has it enabled already.
+	ErrTwoFactorEnabled = errors.New("two-factor authentication already enabled")
+)
+
+// GenerateRecoveryCodes returns n random recovery codes like "k3j9x-2mq7p". They are
+// only shown to the user once, we only store their hashes.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes, nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case and dashes. The codes are
+// random, so a plain SHA-256 hash is enough, like for tokens.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+// TwoFactorModel stores the TOTP secrets and recovery codes of users.
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// Enroll stores a new secret for a user, replacing any unconfirmed one. It only takes
+// effect once it's confirmed with Enable.
+func (m TwoFactorModel) Enroll(userID int64, secret string) error {
+	query := `
+		UPDATE users
+		SET totp_secret = ?, totp_last_step = 0
+		WHERE id = ? AND totp_enabled = FALSE`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, secret, userID)
+	return err
+}
+
+// Enable turns on two-factor authentication for a user if code is valid for the
+// enrolled secret, and stores their recovery codes. It reports whether the code was
+// valid. Like in VerifyCode, a code is accepted only once. Once enabled, Enable fails
+// with ErrTwoFactorEnabled, so a code alone can't replace the recovery codes.
+func (m TwoFactorModel) Enable(userID int64, code string, recoveryCodes []string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var secret string
+	var enabled bool
+	var lastStep int64
+
+	query := `SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = ? FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, userID).Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+
+	if enabled {
+		return false, ErrTwoFactorEnabled
+	}
+
+	if secret == "" {
+		return false, ErrTwoFactorNotEnrolled
+	}
+
+	step, ok, err := totp.ValidateAfter(secret, code, time.Now(), lastStep)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET totp_enabled = TRUE, totp_last_step = ? WHERE id = ?`, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, recoveryCode := range recoveryCodes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, hash) VALUES (?, ?)`, userID, hashRecoveryCode(recoveryCode))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// Disable turns off two-factor authentication and deletes the secret and the recovery
+// codes of a user.
+func (m TwoFactorModel) Disable(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET totp_secret = '', totp_enabled = FALSE, totp_last_step = 0 WHERE id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// VerifyCode reports whether code is a valid TOTP code for a user with two-factor
+// authentication enabled. Each code is accepted only once.
+func (m TwoFactorModel) VerifyCode(userID int64, code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var secret string
+	var lastStep int64
+
+	query := `SELECT totp_secret, totp_last_step FROM users WHERE id = ? AND totp_enabled = TRUE`
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&secret, &lastStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	step, ok, err := totp.ValidateAfter(secret, code, time.Now(), lastStep)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// Claim the time step. If a concurrent request got there first, the code has been
+	// used already.
+	result, err := m.DB.ExecContext(ctx, `UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode reports whether code is an unused recovery code of the user, and
+// marks it as used.
+func (m TwoFactorModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = ?
+		WHERE user_id = ? AND hash = ? AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
@@ -0,0 +1,51 @@
+package data
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("GenerateRecoveryCodes() returned %d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := make(map[string]bool)
+
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("GenerateRecoveryCodes() code %q doesn't look like k3j9x-2mq7p", code)
+		}
+		if seen[code] {
+			t.Errorf("GenerateRecoveryCodes() returned %q twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := hashRecoveryCode("k3j9x-2mq7p")
+
+	for _, code := range []string{"k3j9x2mq7p", "K3J9X-2MQ7P", " k3j9x-2mq7p\n", "k3j9x--2mq7p", "k-3-j-9-x-2-m-q-7-p"} {
+		if got := hashRecoveryCode(code); !bytes.Equal(got, want) {
+			t.Errorf("hashRecoveryCode(%q) differs from hashRecoveryCode(%q)", code, "k3j9x-2mq7p")
+		}
+	}
+
+	for _, code := range []string{"k3j9x-2mq7q", "k3j9x-2mq7", "k3j9x 2mq7p", ""} {
+		if got := hashRecoveryCode(code); bytes.Equal(got, want) {
+			t.Errorf("hashRecoveryCode(%q) equals hashRecoveryCode(%q)", code, "k3j9x-2mq7p")
+		}
+	}
+
+	if len(want) != 32 {
+		t.Errorf("hashRecoveryCode() length = %d, want 32", len(want))
+	}
+}
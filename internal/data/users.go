@@ -8,6 +8,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -41,6 +42,11 @@ type User struct {
 	LastName  string    `json:"last_name"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
+	// PendingEmail is the new email address the user asked for, until it is confirmed.
+	PendingEmail string `json:"pending_email,omitempty"`
+	// TwoFactor is set when the user has confirmed TOTP two-factor authentication.
+	TwoFactor bool `json:"two_factor_enabled"`
+	Version   int  `json:"version"`
 }
 
 // Check if a User instance is the AnonymousUser
@@ -82,6 +88,23 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+var (
+	dummyPasswordOnce sync.Once
+	dummyPassword     password
+)
+
+// MatchesDummyPassword runs the same bcrypt comparison as Matches() against a throwaway
+// hash. Call it when there's no user to check a password for, so that logging in with
+// an unknown email takes as long as with a wrong password and response times don't
+// reveal which emails have an account.
+func MatchesDummyPassword(plaintextPassword string) {
+	dummyPasswordOnce.Do(func() {
+		_ = dummyPassword.Set("not the password of any user")
+	})
+
+	_, _ = dummyPassword.Matches(plaintextPassword)
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid ")
@@ -171,14 +194,52 @@ func (m UserModel) Insert(user *User) error {
 	user.ID = id
 	user.CreatedAt = time.Now()
 	user.Activated = false
+	user.Version = 1
 
 	return nil
 }
 
+// Get retrieves the user details from the database based on the user's id.
+func (m UserModel) Get(id int64) (*User, error) {
+	query := `
+		SELECT id, created_at, first_name, last_name, email, password_hash, activated, pending_email, totp_enabled, version
+		FROM users
+		WHERE id = ?`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.PendingEmail,
+		&user.TwoFactor,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 // GetByEmail retrieve the user details from the database based on the user's email address.
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, created_at, first_name, last_name, email, password_hash, activated
+		SELECT id, created_at, first_name, last_name, email, password_hash, activated, pending_email, totp_enabled, version
 		FROM users
 		WHERE email = ?`
 
@@ -195,6 +256,9 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.PendingEmail,
+		&user.TwoFactor,
+		&user.Version,
 	)
 
 	if err != nil {
@@ -209,12 +273,13 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// Update the details for a specific user.
+// Update the details for a specific user. The version column is used for optimistic
+// locking: if the user was changed since it was read, ErrEditConflict is returned.
 func (m UserModel) Update(user *User) error {
 	query := `
 		UPDATE users
-		SET first_name = ?, last_name = ?, email = ?, password_hash = ?, activated = ?
-		WHERE id = ?`
+		SET first_name = ?, last_name = ?, email = ?, password_hash = ?, activated = ?, pending_email = ?, version = version + 1
+		WHERE id = ? AND version = ?`
 
 	args := []interface{}{
 		user.FirstName,
@@ -222,28 +287,34 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.PendingEmail,
 		user.ID,
+		user.Version,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	result, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
-		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-			if mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "users.email") {
-				return ErrDuplicateEmail
-			}
-		} else {
-			switch {
-			case errors.Is(err, sql.ErrNoRows):
-				return ErrEditConflict
-			default:
-				return err
-			}
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "users.email") {
+			return ErrDuplicateEmail
 		}
+		return err
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	user.Version++
+
 	return nil
 }
 
@@ -253,7 +324,8 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
-		SELECT users.id, users.created_at, users.first_name, users.last_name, users.email, users.password_hash, users.activated
+		SELECT users.id, users.created_at, users.first_name, users.last_name, users.email, users.password_hash, users.activated,
+			users.pending_email, users.totp_enabled, users.version
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
@@ -276,6 +348,9 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.PendingEmail,
+		&user.TwoFactor,
+		&user.Version,
 	)
 
 	if err != nil {
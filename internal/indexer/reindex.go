@@ -0,0 +1,184 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/jsonlog"
+)
+
+// DefaultMaxDrift is the default Reindexer.MaxDrift of cmd/reindex and the API. It
+// leaves room for the books the synchronizers add or delete during a rebuild of a live
+// system.
+const DefaultMaxDrift = 100
+
+// Reindexer rebuilds the books index without downtime. books.Index is treated as an
+// alias: a new versioned index is created next to the live one, loaded from MySQL and
+// checked, and only then is the alias swapped over to it in a single atomic request.
+// Searches keep hitting the old index until the swap.
+//
+// Books keep changing while the new index loads. The new index is registered as being
+// built before loading starts, and the synchronizers write every change to it as well
+// as to the live index (see Syncer). The loaded documents get version 0, lower than
+// any outbox version, so a change the synchronizers wrote always wins over the state
+// the load read, whichever of the two writes lands first.
+type Reindexer struct {
+	Books     data.BookModel
+	Outbox    data.OutboxModel
+	Logger    *jsonlog.Logger
+	BatchSize int
+	// MaxDrift is the number of documents the new index may differ from the catalog
+	// and from the number of loaded books by, to allow for books added or deleted
+	// while the rebuild was running.
+	MaxDrift int
+	// Analysis holds the synonyms and stopwords of the new index.
+	Analysis data.SearchAnalysis
+	// DeleteOld removes the indices the alias pointed at before the swap. Leave them
+	// around to be able to roll back by swapping the alias back.
+	DeleteOld bool
+}
+
+// Run performs the rebuild and returns the name of the new index. If anything goes
+// wrong before the swap, or ctx is cancelled while the books are loaded, the new index
+// is deleted, its build is marked as failed and the alias is left untouched.
+func (r *Reindexer) Run(ctx context.Context) (string, error) {
+	alias := r.Books.Index
+	newIndex := fmt.Sprintf("%s-%s", alias, time.Now().UTC().Format("20060102150405"))
+
+	oldIndices, isConcrete, err := r.Books.AliasedIndices()
+	if err != nil {
+		return "", err
+	}
+
+	r.Logger.PrintInfo("creating index", map[string]string{"index": newIndex, "alias": alias})
+
+	if err := r.Books.CreateIndex(newIndex, r.Analysis); err != nil {
+		return "", err
+	}
+
+	if err := r.Outbox.StartBuild(newIndex); err != nil {
+		r.deleteIndex(newIndex)
+		return "", err
+	}
+
+	// Point a copy of the model at the new index, so the bulk requests go there
+	// instead of through the alias.
+	target := r.Books
+	target.Index = newIndex
+
+	total, err := r.load(ctx, target)
+	if err == nil {
+		err = r.verify(target, total)
+	}
+	if err == nil {
+		err = r.Books.SwapAlias(newIndex, oldIndices, isConcrete)
+	}
+	if err != nil {
+		r.deleteIndex(newIndex)
+		if failErr := r.Outbox.FailBuild(newIndex); failErr != nil {
+			r.Logger.PrintError(failErr, map[string]string{"index": newIndex})
+		}
+		return "", err
+	}
+
+	// The alias points at the new index, so the synchronizers can stop writing to it
+	// separately.
+	if err := r.Outbox.FinishBuild(newIndex); err != nil {
+		r.Logger.PrintError(err, map[string]string{"index": newIndex})
+	}
+
+	r.Logger.PrintInfo("alias swapped", map[string]string{
+		"alias":   alias,
+		"index":   newIndex,
+		"indexed": strconv.Itoa(total),
+	})
+
+	if r.DeleteOld {
+		if err := r.Books.DeleteIndices(oldIndices); err != nil {
+			return newIndex, err
+		}
+	}
+
+	return newIndex, nil
+}
+
+// deleteIndex deletes the new index of a rebuild which failed.
+func (r *Reindexer) deleteIndex(index string) {
+	if err := r.Books.DeleteIndices([]string{index}); err != nil {
+		r.Logger.PrintError(err, map[string]string{"index": index})
+	}
+}
+
+// load copies the whole catalog into the target index in batches and returns the
+// number of books indexed. It stops between batches when ctx is cancelled.
+func (r *Reindexer) load(ctx context.Context, target data.BookModel) (int, error) {
+	var afterID int64
+	var total int
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		books, err := target.ListAfter(afterID, r.BatchSize)
+		if err != nil {
+			return total, err
+		}
+
+		if len(books) == 0 {
+			break
+		}
+
+		// Version 0 loses against every change the synchronizers write to the new
+		// index, see Reindexer.
+		if err := target.BulkIndex(books, 0); err != nil {
+			return total, err
+		}
+
+		total += len(books)
+		afterID = books[len(books)-1].ID
+
+		r.Logger.PrintInfo("indexed batch", map[string]string{
+			"indexed": strconv.Itoa(total),
+			"last_id": strconv.FormatInt(afterID, 10),
+		})
+	}
+
+	return total, target.FinishLoading(target.Index)
+}
+
+// verify checks that the new index holds every book we loaded, and that it's still in
+// line with the catalog.
+func (r *Reindexer) verify(target data.BookModel, loaded int) error {
+	indexed, err := target.CountDocuments(target.Index)
+	if err != nil {
+		return err
+	}
+
+	// The synchronizers add and remove documents while we load, so the counts only
+	// have to be close.
+	if abs(indexed-loaded) > r.MaxDrift {
+		return fmt.Errorf("new index holds %d documents but %d books were loaded", indexed, loaded)
+	}
+
+	catalog, err := target.CountAll()
+	if err != nil {
+		return err
+	}
+
+	if abs(catalog-indexed) > r.MaxDrift {
+		return fmt.Errorf("new index holds %d documents but the catalog has %d books", indexed, catalog)
+	}
+
+	return nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
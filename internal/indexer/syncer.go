@@ -0,0 +1,167 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hafizmfadli/hello-nerds-api/internal/data"
+	"github.com/hafizmfadli/hello-nerds-api/internal/jsonlog"
+)
+
+// Syncer drains the books outbox into Elasticsearch. Every entry only says which book
+// changed, so the Syncer loads the current state of those books from MySQL and sends
+// it in one bulk request: books that still exist are (re-)indexed, books that are gone
+// are removed from the index.
+//
+// Entries aren't claimed, so several API instances running a Syncer side by side may
+// process the same entries, or different entries of the same book, at the same time.
+// The documents are versioned with the id of the latest outbox entry of their book
+// (see BookModel.BulkSync), and an entry is only written after the change it records
+// was committed, so a document is never replaced by the state of an earlier read. The
+// last entry of a book always wins, processing an entry twice is harmless. This relies
+// on entry ids never going back, which MySQL 8 guarantees by keeping the
+// AUTO_INCREMENT counter across restarts.
+//
+// Documents indexed before versioning was introduced only carry Elasticsearch's own
+// version counter; run cmd/reindex once to give every document an outbox version.
+type Syncer struct {
+	Models       data.Models
+	Logger       *jsonlog.Logger
+	BatchSize    int
+	PollInterval time.Duration
+}
+
+// maxRetryDelay caps the exponential backoff of failing outbox entries.
+const maxRetryDelay = 5 * time.Minute
+
+// Run drains the outbox until ctx is cancelled. When there's a backlog it keeps going
+// batch after batch, otherwise it waits PollInterval between polls.
+func (s *Syncer) Run(ctx context.Context) {
+	s.Logger.PrintInfo("starting search index synchronizer", map[string]string{
+		"poll_interval": s.PollInterval.String(),
+	})
+
+	for {
+		n, err := s.Drain()
+		if err != nil {
+			s.Logger.PrintError(err, map[string]string{"component": "search-sync"})
+		}
+
+		// Only sleep when the outbox is empty (or broken), a full batch means there
+		// is probably more waiting.
+		if err != nil || n < s.BatchSize {
+			select {
+			case <-ctx.Done():
+				s.Logger.PrintInfo("stopped search index synchronizer", nil)
+				return
+			case <-time.After(s.PollInterval):
+			}
+		} else if ctx.Err() != nil {
+			s.Logger.PrintInfo("stopped search index synchronizer", nil)
+			return
+		}
+	}
+}
+
+// Drain processes a single batch of outbox entries and returns the number of entries
+// in the batch. Entries which fail are scheduled for a retry with exponential backoff.
+func (s *Syncer) Drain() (int, error) {
+	entries, err := s.Models.Outbox.Pending(s.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	// The same book is often changed several times in a row, we only need to sync it
+	// once.
+	var entryIDs []int64
+	var bookIDs []int64
+	versions := make(map[int64]int64)
+	attempts := 0
+
+	for _, entry := range entries {
+		entryIDs = append(entryIDs, entry.ID)
+		if entry.Attempts > attempts {
+			attempts = entry.Attempts
+		}
+		if _, ok := versions[entry.BookID]; !ok {
+			bookIDs = append(bookIDs, entry.BookID)
+		}
+		if entry.ID > versions[entry.BookID] {
+			versions[entry.BookID] = entry.ID
+		}
+	}
+
+	err = s.sync(bookIDs, versions)
+	if err != nil {
+		retryErr := s.Models.Outbox.Retry(entryIDs, retryDelay(attempts), err.Error())
+		if retryErr != nil {
+			return len(entries), fmt.Errorf("%v (and scheduling the retry failed: %v)", err, retryErr)
+		}
+		return len(entries), err
+	}
+
+	return len(entries), s.Models.Outbox.Delete(entryIDs)
+}
+
+// sync indexes the books which still exist and removes the others from the index,
+// with the given document versions.
+func (s *Syncer) sync(bookIDs []int64, versions map[int64]int64) error {
+	books, err := s.Models.Books.GetBooks(bookIDs)
+	if err != nil {
+		return err
+	}
+
+	found := make(map[int64]bool)
+	for _, book := range books {
+		found[book.ID] = true
+	}
+
+	var deletedIDs []int64
+	for _, id := range bookIDs {
+		if !found[id] {
+			deletedIDs = append(deletedIDs, id)
+		}
+	}
+
+	// Look up the indices being rebuilt only now, after reading the books. A rebuild
+	// registers its index before it starts loading, so if we don't see it here, the
+	// rebuild reads the books after we did and has their changes already.
+	builds, err := s.Models.Outbox.Builds()
+	if err != nil {
+		return err
+	}
+
+	err = s.Models.Books.BulkSync(books, deletedIDs, versions)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range builds {
+		err = s.Models.Books.BulkSyncIndex(index, books, deletedIDs, versions)
+		if err != nil {
+			return fmt.Errorf("index %s: %w", index, err)
+		}
+	}
+
+	return nil
+}
+
+// retryDelay returns the backoff for an entry which already failed the given number
+// of times: 1s, 2s, 4s, ... up to maxRetryDelay.
+func retryDelay(attempts int) time.Duration {
+	if attempts > 16 {
+		return maxRetryDelay
+	}
+
+	delay := time.Duration(1<<uint(attempts)) * time.Second
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+
+	return delay
+}
@@ -0,0 +1,155 @@
+// Package isbn validates and converts ISBN-10 and ISBN-13 book numbers.
+//
+// Every function accepts ISBNs with or without hyphens and spaces ("978-0-13-110362-7",
+// "0 13 110362 8"). The canonical form used throughout the API is the bare ISBN-13.
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalid is returned for anything that isn't a valid ISBN-10 or ISBN-13.
+var ErrInvalid = errors.New("invalid ISBN")
+
+// Normalize strips the hyphens and spaces from an ISBN and upper-cases the "x" check
+// digit of an ISBN-10. It doesn't validate anything.
+func Normalize(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '-' || r == ' ':
+			continue
+		case r == 'x':
+			sb.WriteRune('X')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// Valid reports whether s is a valid ISBN-10 or ISBN-13.
+func Valid(s string) bool {
+	s = Normalize(s)
+	return valid10(s) || valid13(s)
+}
+
+// Parse validates s and returns it as a bare ISBN-13. ISBN-10s are converted.
+func Parse(s string) (string, error) {
+	s = Normalize(s)
+
+	switch {
+	case valid13(s):
+		return s, nil
+	case valid10(s):
+		return to13(s), nil
+	default:
+		return "", ErrInvalid
+	}
+}
+
+// To13 converts an ISBN-10 to an ISBN-13. A valid ISBN-13 is returned as is.
+func To13(s string) (string, error) {
+	return Parse(s)
+}
+
+// To10 converts an ISBN-13 to an ISBN-10. Only ISBN-13s with the 978 prefix have an
+// ISBN-10 equivalent, for any other the error is ErrInvalid. A valid ISBN-10 is
+// returned as is.
+func To10(s string) (string, error) {
+	s = Normalize(s)
+
+	switch {
+	case valid10(s):
+		return s, nil
+	case valid13(s) && strings.HasPrefix(s, "978"):
+		body := s[3:12]
+		return body + string(checkDigit10(body)), nil
+	default:
+		return "", ErrInvalid
+	}
+}
+
+// ParseList splits a comma separated list of ISBNs (the format of the Identifier
+// column) and returns the valid ones as bare ISBN-13s, without duplicates. Invalid
+// entries are skipped.
+func ParseList(s string) []string {
+	var isbns []string
+	seen := make(map[string]bool)
+
+	for _, field := range strings.Split(s, ",") {
+		isbn13, err := Parse(field)
+		if err != nil || seen[isbn13] {
+			continue
+		}
+		seen[isbn13] = true
+		isbns = append(isbns, isbn13)
+	}
+
+	return isbns
+}
+
+// valid10 checks the length, digits and check digit of a normalized ISBN-10. The
+// weighted sum (10 for the first digit down to 1 for the check digit) must be a
+// multiple of 11, where an X check digit stands for 10.
+func valid10(s string) bool {
+	if len(s) != 10 || !digits(s[:9]) {
+		return false
+	}
+
+	return s[9] == checkDigit10(s[:9])
+}
+
+// valid13 checks the length, digits and check digit of a normalized ISBN-13. The digits
+// are weighted alternately 1 and 3 and the sum must be a multiple of 10.
+func valid13(s string) bool {
+	if len(s) != 13 || !digits(s) {
+		return false
+	}
+
+	return s[12] == checkDigit13(s[:12])
+}
+
+// to13 converts a valid, normalized ISBN-10.
+func to13(s string) string {
+	body := "978" + s[:9]
+	return body + string(checkDigit13(body))
+}
+
+// checkDigit10 computes the check digit for the first 9 digits of an ISBN-10.
+func checkDigit10(body string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(body[i]-'0') * (10 - i)
+	}
+
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+// checkDigit13 computes the check digit for the first 12 digits of an ISBN-13.
+func checkDigit13(body string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(body[i]-'0') * weight
+	}
+
+	return byte('0' + (10-sum%10)%10)
+}
+
+func digits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package isbn
+
+import "testing"
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name string
+		isbn string
+		want bool
+	}{
+		{"ISBN-10", "0131103628", true},
+		{"ISBN-10 with hyphens", "0-13-110362-8", true},
+		{"ISBN-10 with spaces", "0 13 110362 8", true},
+		{"ISBN-10 with X check digit", "080442957X", true},
+		{"ISBN-10 with lower-case x check digit", "080442957x", true},
+		{"ISBN-10 with wrong check digit", "0131103627", false},
+		{"ISBN-10 with X in the body", "08044295X7", false},
+		{"ISBN-13", "9780131103627", true},
+		{"ISBN-13 with hyphens", "978-0-13-110362-7", true},
+		{"ISBN-13 with 979 prefix", "9791034304141", true},
+		{"ISBN-13 with wrong check digit", "9780131103628", false},
+		{"ISBN-13 with X check digit", "978013110362X", false},
+		{"too short", "013110362", false},
+		{"too long", "97801311036277", false},
+		{"letters", "abcdefghij", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Valid(tt.isbn); got != tt.want {
+				t.Errorf("Valid(%q) = %v, want %v", tt.isbn, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTo13(t *testing.T) {
+	tests := []struct {
+		name    string
+		isbn    string
+		want    string
+		wantErr bool
+	}{
+		{"ISBN-10", "0131103628", "9780131103627", false},
+		{"ISBN-10 with hyphens", "0-13-110362-8", "9780131103627", false},
+		{"ISBN-10 with X check digit", "080442957X", "9780804429573", false},
+		{"ISBN-13 is returned as is", "978-0-13-110362-7", "9780131103627", false},
+		{"invalid ISBN-10", "0131103627", "", true},
+		{"invalid ISBN-13", "9780131103628", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := To13(tt.isbn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("To13(%q) error = %v, wantErr %v", tt.isbn, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("To13(%q) = %q, want %q", tt.isbn, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTo10(t *testing.T) {
+	tests := []struct {
+		name    string
+		isbn    string
+		want    string
+		wantErr bool
+	}{
+		{"ISBN-13", "9780131103627", "0131103628", false},
+		{"ISBN-13 with hyphens", "978-0-13-110362-7", "0131103628", false},
+		{"ISBN-13 with X check digit in ISBN-10", "9780804429573", "080442957X", false},
+		{"ISBN-10 is returned as is", "0-13-110362-8", "0131103628", false},
+		{"ISBN-13 with 979 prefix", "9791034304141", "", true},
+		{"invalid ISBN-13", "9780131103628", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := To10(tt.isbn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("To10(%q) error = %v, wantErr %v", tt.isbn, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("To10(%q) = %q, want %q", tt.isbn, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, isbn10 := range []string{"0131103628", "080442957X", "0306406152", "1861972717"} {
+		isbn13, err := To13(isbn10)
+		if err != nil {
+			t.Fatalf("To13(%q) error = %v", isbn10, err)
+		}
+
+		got, err := To10(isbn13)
+		if err != nil {
+			t.Fatalf("To10(%q) error = %v", isbn13, err)
+		}
+		if got != isbn10 {
+			t.Errorf("To10(To13(%q)) = %q", isbn10, got)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+	got := ParseList("0131103628, 978-0-13-110362-7,not an isbn,9780804429573")
+	want := []string{"9780131103627", "9780804429573"}
+
+	if len(got) != len(want) {
+		t.Fatalf("ParseList() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ParseList()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
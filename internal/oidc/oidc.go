@@ -0,0 +1,475 @@
+// Package oidc implements the relying party side of OpenID Connect logins: the
+// authorization code flow with PKCE, and verification of RS256 signed ID tokens against
+// the keys the provider publishes. Providers are configured by their issuer URL, the
+// endpoints are found through discovery (/.well-known/openid-configuration).
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned for ID tokens which are malformed, expired, not
+	// meant for us, or whose signature doesn't match.
+	ErrInvalidToken = errors.New("oidc: invalid id token")
+	// ErrInvalidCode is returned when the provider refuses to exchange an
+	// authorization code, because it's wrong, expired, used already, or doesn't match
+	// the PKCE verifier.
+	ErrInvalidCode = errors.New("oidc: authorization code rejected")
+)
+
+// Leeway is the clock skew allowed when checking the expiry and issue time of ID
+// tokens.
+const Leeway = time.Minute
+
+// keysRefreshInterval is how often the provider keys may be fetched again because of
+// a token signed with an unknown key, so bogus tokens can't make us hammer the provider.
+const keysRefreshInterval = time.Minute
+
+// Config is the configuration of a provider, as registered with it.
+type Config struct {
+	// Name identifies the provider in our URLs, like "google".
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where the provider sends the user back to with the code.
+	RedirectURL string
+}
+
+// Claims are the claims of an ID token we care about.
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Expiry          int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	EmailVerified   boolish  `json:"email_verified"`
+	Name            string   `json:"name"`
+	GivenName       string   `json:"given_name"`
+	FamilyName      string   `json:"family_name"`
+}
+
+// audience is the aud claim, which is either a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, aud := range a {
+		if aud == s {
+			return true
+		}
+	}
+	return false
+}
+
+// boolish is a boolean claim some providers send as a "true" or "false" string.
+type boolish bool
+
+func (b *boolish) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	case "false", "null":
+		*b = false
+	default:
+		return fmt.Errorf("oidc: invalid boolean %s", data)
+	}
+	return nil
+}
+
+// metadata is the part of the discovery document we use.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect provider. The discovery document and the signing keys
+// are fetched when first needed and cached.
+type Provider struct {
+	Config
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *metadata
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+// NewProvider returns a Provider which talks to the provider with client.
+func NewProvider(cfg Config, client *http.Client) *Provider {
+	return &Provider{Config: cfg, client: client}
+}
+
+// ParseProviders parses a comma separated list of providers in the
+// name|issuer|client_id|client_secret format. The redirect URL is the same for all of
+// them.
+func ParseProviders(s, redirectURL string, client *http.Client) (map[string]*Provider, error) {
+	providers := make(map[string]*Provider)
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.Split(entry, "|")
+		if len(fields) != 4 || fields[0] == "" || fields[1] == "" || fields[2] == "" {
+			return nil, fmt.Errorf("oidc: provider %q must be in the name|issuer|client_id|client_secret format", strings.SplitN(entry, "|", 2)[0])
+		}
+
+		if _, ok := providers[fields[0]]; ok {
+			return nil, fmt.Errorf("oidc: duplicate provider %q", fields[0])
+		}
+
+		providers[fields[0]] = NewProvider(Config{
+			Name:         fields[0],
+			Issuer:       strings.TrimSuffix(fields[1], "/"),
+			ClientID:     fields[2],
+			ClientSecret: fields[3],
+			RedirectURL:  redirectURL,
+		}, client)
+	}
+
+	if len(providers) > 0 && redirectURL == "" {
+		return nil, errors.New("oidc: a redirect URL is required")
+	}
+
+	return providers, nil
+}
+
+// Random returns a random URL safe string, to be used as state, nonce or PKCE code
+// verifier.
+func Random() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE code challenge for a code verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL of the provider's login page the user has to be sent to.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", "openid email profile")
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", Challenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(md.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return md.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange trades an authorization code for tokens and returns the claims of the
+// verified ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// The token endpoint answers 400 for codes it won't exchange, see RFC 6749
+	// section 5.2.
+	if res.StatusCode == http.StatusBadRequest || res.StatusCode == http.StatusUnauthorized {
+		return nil, ErrInvalidCode
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token endpoint returned %s", res.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&tokens)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: decoding token response: %w", err)
+	}
+
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc: token response without id_token")
+	}
+
+	return p.Verify(ctx, tokens.IDToken, nonce, time.Now())
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an ID token and
+// returns its claims.
+func (p *Provider) Verify(ctx context.Context, token, nonce string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// Only accept the algorithm we expect, never whatever the token says it uses.
+	if h.Algorithm != "RS256" {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := p.key(ctx, h.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	switch {
+	case claims.Issuer != p.Issuer:
+		return nil, ErrInvalidToken
+	case claims.Subject == "":
+		return nil, ErrInvalidToken
+	case !claims.Audience.contains(p.ClientID):
+		return nil, ErrInvalidToken
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID:
+		return nil, ErrInvalidToken
+	case now.After(time.Unix(claims.Expiry, 0).Add(Leeway)):
+		return nil, ErrInvalidToken
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(Leeway)):
+		return nil, ErrInvalidToken
+	case claims.Nonce != nonce:
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// discover returns the discovery document of the provider.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	md := p.metadata
+	p.mu.Unlock()
+
+	if md != nil {
+		return md, nil
+	}
+
+	md = &metadata{}
+
+	err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", md)
+	if err != nil {
+		return nil, err
+	}
+
+	// The issuer in the document must be the one we were configured with, or the
+	// tokens it issues won't verify anyway.
+	if md.Issuer != p.Issuer {
+		return nil, fmt.Errorf("oidc: provider %q reports issuer %q", p.Name, md.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: incomplete discovery document of provider %q", p.Name)
+	}
+
+	p.mu.Lock()
+	p.metadata = md
+	p.mu.Unlock()
+
+	return md, nil
+}
+
+// key returns the signing key with the given id. The keys are fetched again when the
+// id is unknown, since providers rotate their keys.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	stale := time.Since(p.keysFetchedAt) > keysRefreshInterval
+	p.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, ErrInvalidToken
+	}
+
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := p.fetchKeys(ctx, md.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return key, nil
+}
+
+// fetchKeys loads the RSA signing keys of a JSON Web Key Set (RFC 7517).
+func (p *Provider) fetchKeys(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			Use     string `json:"use"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err := p.getJSON(ctx, jwksURI, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+
+		keys[k.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}
+	}
+
+	return keys, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s returned %s", url, res.Status)
+	}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(dst)
+	if err != nil {
+		return fmt.Errorf("oidc: decoding %s: %w", url, err)
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,450 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "client"
+	testClientSecret = "secret"
+	testRedirectURL  = "https://app.example.com/callback"
+)
+
+// testProvider is an OpenID Connect provider serving discovery, the signing keys and a
+// token endpoint which checks PKCE verifiers.
+type testProvider struct {
+	server *httptest.Server
+	issuer string
+
+	mu         sync.Mutex
+	keys       map[string]*rsa.PrivateKey
+	challenges map[string]string
+	idTokens   map[string]string
+	keyFetches int
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+
+	tp := &testProvider{
+		keys:       make(map[string]*rsa.PrivateKey),
+		challenges: make(map[string]string),
+		idTokens:   make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 tp.issuer,
+			"authorization_endpoint": tp.issuer + "/authorize",
+			"token_endpoint":         tp.issuer + "/token",
+			"jwks_uri":               tp.issuer + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", tp.serveKeys)
+	mux.HandleFunc("/token", tp.serveToken)
+
+	tp.server = httptest.NewServer(mux)
+	tp.issuer = tp.server.URL
+	t.Cleanup(tp.server.Close)
+
+	tp.addKey(t, "key-1")
+
+	return tp
+}
+
+func (tp *testProvider) addKey(t *testing.T, kid string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp.mu.Lock()
+	tp.keys[kid] = key
+	tp.mu.Unlock()
+}
+
+func (tp *testProvider) serveKeys(w http.ResponseWriter, r *http.Request) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	tp.keyFetches++
+
+	var keys []map[string]string
+	for kid, key := range tp.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"use": "sig",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+// fetches returns how often the keys were fetched.
+func (tp *testProvider) fetches() int {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	return tp.keyFetches
+}
+
+func (tp *testProvider) serveToken(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if !ok || user != testClientID || password != testClientSecret {
+		http.Error(w, `{"error": "invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	code := r.PostFormValue("code")
+
+	tp.mu.Lock()
+	challenge, ok := tp.challenges[code]
+	idToken := tp.idTokens[code]
+	delete(tp.challenges, code)
+	tp.mu.Unlock()
+
+	if !ok || r.PostFormValue("redirect_uri") != testRedirectURL || Challenge(r.PostFormValue("code_verifier")) != challenge {
+		http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+}
+
+// authorize does what the provider's login page does: it issues a code for the
+// authorization URL, which is exchanged for idToken.
+func (tp *testProvider) authorize(t *testing.T, authorizationURL, idToken string) string {
+	t.Helper()
+
+	u, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != testClientID {
+		t.Fatalf("unexpected authorization URL %s", authorizationURL)
+	}
+
+	code := "code-" + q.Get("state")
+
+	tp.mu.Lock()
+	tp.challenges[code] = q.Get("code_challenge")
+	tp.idTokens[code] = idToken
+	tp.mu.Unlock()
+
+	return code
+}
+
+// sign returns a token with the given header and claims, signed with the key kid.
+func (tp *testProvider) sign(t *testing.T, header map[string]string, claims map[string]interface{}) string {
+	t.Helper()
+
+	tp.mu.Lock()
+	key := tp.keys[header["kid"]]
+	tp.mu.Unlock()
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// claims returns valid claims of an ID token for the nonce.
+func (tp *testProvider) claims(nonce string) map[string]interface{} {
+	now := time.Now()
+
+	return map[string]interface{}{
+		"iss":            tp.issuer,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          "user@example.com",
+		"email_verified": true,
+	}
+}
+
+func (tp *testProvider) provider() *Provider {
+	return NewProvider(Config{
+		Name:         "test",
+		Issuer:       tp.issuer,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	}, tp.server.Client())
+}
+
+func TestExchange(t *testing.T) {
+	tp := newTestProvider(t)
+	p := tp.provider()
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		verifier func(verifier string) string
+		wantErr  error
+	}{
+		{"matching verifier", func(verifier string) string { return verifier }, nil},
+		{"other verifier", func(string) string { return "other-verifier" }, ErrInvalidCode},
+		{"no verifier", func(string) string { return "" }, ErrInvalidCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, nonce, verifier := random(t), random(t), random(t)
+
+			authorizationURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			idToken := tp.sign(t, map[string]string{"alg": "RS256", "kid": "key-1"}, tp.claims(nonce))
+			code := tp.authorize(t, authorizationURL, idToken)
+
+			claims, err := p.Exchange(ctx, code, tt.verifier(verifier), nonce)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Exchange() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if claims.Subject != "user-1" || claims.Email != "user@example.com" || !bool(claims.EmailVerified) {
+				t.Errorf("Exchange() = %+v", claims)
+			}
+		})
+	}
+
+	t.Run("used code", func(t *testing.T) {
+		nonce, verifier := random(t), random(t)
+
+		authorizationURL, err := p.AuthCodeURL(ctx, random(t), nonce, verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		idToken := tp.sign(t, map[string]string{"alg": "RS256", "kid": "key-1"}, tp.claims(nonce))
+		code := tp.authorize(t, authorizationURL, idToken)
+
+		if _, err := p.Exchange(ctx, code, verifier, nonce); err != nil {
+			t.Fatalf("Exchange() error = %v", err)
+		}
+		if _, err := p.Exchange(ctx, code, verifier, nonce); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("second Exchange() error = %v, want %v", err, ErrInvalidCode)
+		}
+	})
+}
+
+func TestVerify(t *testing.T) {
+	tp := newTestProvider(t)
+	p := tp.provider()
+
+	const nonce = "nonce"
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		header  map[string]string
+		modify  func(claims map[string]interface{})
+		wantErr bool
+	}{
+		{"valid", nil, nil, false},
+		{"aud array with azp", nil, func(c map[string]interface{}) {
+			c["aud"] = []string{testClientID, "other"}
+			c["azp"] = testClientID
+		}, false},
+		{"email_verified string", nil, func(c map[string]interface{}) { c["email_verified"] = "true" }, false},
+		{"expired within leeway", nil, func(c map[string]interface{}) { c["exp"] = now.Add(-Leeway / 2).Unix() }, false},
+		{"wrong nonce", nil, func(c map[string]interface{}) { c["nonce"] = "other" }, true},
+		{"no nonce", nil, func(c map[string]interface{}) { delete(c, "nonce") }, true},
+		{"wrong issuer", nil, func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, true},
+		{"wrong audience", nil, func(c map[string]interface{}) { c["aud"] = "other" }, true},
+		{"aud array without azp", nil, func(c map[string]interface{}) { c["aud"] = []string{testClientID, "other"} }, true},
+		{"aud array with other azp", nil, func(c map[string]interface{}) {
+			c["aud"] = []string{testClientID, "other"}
+			c["azp"] = "other"
+		}, true},
+		{"expired", nil, func(c map[string]interface{}) { c["exp"] = now.Add(-2 * Leeway).Unix() }, true},
+		{"issued in the future", nil, func(c map[string]interface{}) { c["iat"] = now.Add(2 * Leeway).Unix() }, true},
+		{"no subject", nil, func(c map[string]interface{}) { delete(c, "sub") }, true},
+		{"alg none", map[string]string{"alg": "none", "kid": "key-1"}, nil, true},
+		{"alg HS256", map[string]string{"alg": "HS256", "kid": "key-1"}, nil, true},
+		{"alg RS512", map[string]string{"alg": "RS512", "kid": "key-1"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = map[string]string{"alg": "RS256", "kid": "key-1"}
+			}
+
+			claims := tp.claims(nonce)
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+
+			_, err := p.Verify(context.Background(), tp.sign(t, header, claims), nonce, now)
+			if tt.wantErr && !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Verify() error = %v, want %v", err, ErrInvalidToken)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+		})
+	}
+
+	t.Run("tampered signature", func(t *testing.T) {
+		token := tp.sign(t, map[string]string{"alg": "RS256", "kid": "key-1"}, tp.claims(nonce))
+		claims := tp.claims(nonce)
+		claims["sub"] = "user-2"
+		other := tp.sign(t, map[string]string{"alg": "RS256", "kid": "key-1"}, claims)
+
+		// The claims of one token with the signature of the other.
+		tampered := other[:len(other)-len(signatureOf(other))] + signatureOf(token)
+
+		if _, err := p.Verify(context.Background(), tampered, nonce, now); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Verify() error = %v, want %v", err, ErrInvalidToken)
+		}
+	})
+}
+
+func TestVerifyUnknownKey(t *testing.T) {
+	tp := newTestProvider(t)
+	p := tp.provider()
+	ctx := context.Background()
+
+	const nonce = "nonce"
+
+	_, err := p.Verify(ctx, tp.sign(t, map[string]string{"alg": "RS256", "kid": "key-1"}, tp.claims(nonce)), nonce, time.Now())
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	// The provider rotates its keys.
+	tp.addKey(t, "key-2")
+	token := tp.sign(t, map[string]string{"alg": "RS256", "kid": "key-2"}, tp.claims(nonce))
+
+	// The keys were just fetched, so they aren't fetched again right away.
+	_, err = p.Verify(ctx, token, nonce, time.Now())
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify() with recently fetched keys error = %v, want %v", err, ErrInvalidToken)
+	}
+	if n := tp.fetches(); n != 1 {
+		t.Fatalf("keys fetched %d times, want 1", n)
+	}
+
+	p.mu.Lock()
+	p.keysFetchedAt = time.Now().Add(-2 * keysRefreshInterval)
+	p.mu.Unlock()
+
+	_, err = p.Verify(ctx, token, nonce, time.Now())
+	if err != nil {
+		t.Fatalf("Verify() after the refresh interval error = %v", err)
+	}
+	if n := tp.fetches(); n != 2 {
+		t.Fatalf("keys fetched %d times, want 2", n)
+	}
+
+	// A key the provider doesn't have is still unknown after fetching the keys.
+	p.mu.Lock()
+	p.keysFetchedAt = time.Now().Add(-2 * keysRefreshInterval)
+	p.mu.Unlock()
+
+	_, err = p.Verify(ctx, unknownKeyToken(t, tp, nonce), nonce, time.Now())
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify() with an unknown key error = %v, want %v", err, ErrInvalidToken)
+	}
+	if n := tp.fetches(); n != 3 {
+		t.Fatalf("keys fetched %d times, want 3", n)
+	}
+}
+
+func TestParseProviders(t *testing.T) {
+	providers, err := ParseProviders("google|https://accounts.google.com/|id|secret, other|https://id.example.com|id2|", testRedirectURL, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("ParseProviders() error = %v", err)
+	}
+	if len(providers) != 2 || providers["google"].Issuer != "https://accounts.google.com" || providers["other"].ClientSecret != "" {
+		t.Fatalf("ParseProviders() = %+v", providers)
+	}
+
+	for _, s := range []string{"google|https://accounts.google.com|id", "a|https://a|id|s,a|https://b|id|s", "|https://a|id|s"} {
+		if _, err := ParseProviders(s, testRedirectURL, http.DefaultClient); err == nil {
+			t.Errorf("ParseProviders(%q) error = nil", s)
+		}
+	}
+
+	if _, err := ParseProviders("a|https://a|id|s", "", http.DefaultClient); err == nil {
+		t.Error("ParseProviders() without redirect URL error = nil")
+	}
+}
+
+// unknownKeyToken returns a token signed with a key the provider doesn't publish.
+func unknownKeyToken(t *testing.T, tp *testProvider, nonce string) string {
+	t.Helper()
+
+	tp.addKey(t, "key-3")
+	token := tp.sign(t, map[string]string{"alg": "RS256", "kid": "key-3"}, tp.claims(nonce))
+
+	tp.mu.Lock()
+	delete(tp.keys, "key-3")
+	tp.mu.Unlock()
+
+	return token
+}
+
+func signatureOf(token string) string {
+	for i := len(token) - 1; i >= 0; i-- {
+		if token[i] == '.' {
+			return token[i+1:]
+		}
+	}
+	return ""
+}
+
+func random(t *testing.T) string {
+	t.Helper()
+
+	s, err := Random()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
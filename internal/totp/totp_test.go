@@ -0,0 +1,153 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the RFC 6238 test vectors, "12345678901234567890".
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1. The RFC uses 8 digits, our 6 digit codes are the
+	// last 6 of them.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code() at %d error = %v", tt.unix, err)
+		}
+		if want := tt.want[len(tt.want)-Digits:]; got != want {
+			t.Errorf("Code() at %d = %q, want %q", tt.unix, got, want)
+		}
+	}
+}
+
+func TestCodeLowerCaseSecret(t *testing.T) {
+	upper, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lower, err := Code(strings.ToLower(rfcSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if upper != lower {
+		t.Errorf("Code() with a lower case secret = %q, want %q", lower, upper)
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code() with an invalid secret error = nil")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", code(current), current, true},
+		{"previous step", code(current - Skew), current - Skew, true},
+		{"next step", code(current + Skew), current + Skew, true},
+		{"with spaces", " " + code(current) + " ", current, true},
+		{"too old", code(current - Skew - 1), 0, false},
+		{"too new", code(current + Skew + 1), 0, false},
+		{"too short", code(current)[1:], 0, false},
+		{"wrong code", "000000", 0, false},
+		{"empty", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok, err := Validate(rfcSecret, tt.code, now)
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("Validate(%q) = %d, %v, want %d, %v", tt.code, step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateAfter(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	codeNow, err := Code(rfcSecret, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codeBefore, err := Code(rfcSecret, current-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantOK   bool
+	}{
+		{"after the last step", codeNow, current - 1, true},
+		{"at the last step", codeNow, current, false},
+		{"before the last step", codeBefore, current, false},
+		{"skew window after the last step", codeBefore, current - 2, true},
+		{"skew window at the last step", codeBefore, current - 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok, err := ValidateAfter(rfcSecret, tt.code, now, tt.lastStep)
+			if err != nil {
+				t.Fatalf("ValidateAfter() error = %v", err)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("ValidateAfter(%q, last step %d) = %v, want %v", tt.code, tt.lastStep, ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("GenerateSecret() = %q, not base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("GenerateSecret() key length = %d, want 20", len(key))
+	}
+}
@@ -2,8 +2,6 @@ package validator
 
 import (
 	"regexp"
-
-	"github.com/go-playground/validator/v10"
 )
 
 // Declare a regular expression for sanity checking the format of email addresses (we'll
@@ -25,12 +23,6 @@ func New() *Validator {
 	return &Validator{Errors: make(map[string]string)}
 }
 
-// NewGoPlayground return validator from go playground (3rd party)
-// visit their repository https://github.com/go-playground/validator
-func NewGoPlayground() *validator.Validate {
-	return validator.New()
-}
-
 // Valid returns true if the errors map doesn't contain any entries.
 func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
@@ -0,0 +1 @@
+ALTER TABLE updated_edited DROP COLUMN version;
@@ -0,0 +1 @@
+ALTER TABLE updated_edited ADD COLUMN version INT NOT NULL DEFAULT 1;
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS books_outbox_after_insert;
+DROP TRIGGER IF EXISTS books_outbox_after_update;
+DROP TRIGGER IF EXISTS books_outbox_after_delete;
+DROP TABLE IF EXISTS books_outbox;
@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS books_outbox (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_edited_id INT UNSIGNED NOT NULL,
+  attempts INT NOT NULL DEFAULT 0,
+  available_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_error VARCHAR(1000),
+  PRIMARY KEY (id),
+  KEY idx_books_outbox_available_at (available_at)
+) ENGINE=InnoDB;
+
+-- Every change to a book is recorded in the outbox by a trigger, so the outbox row is
+-- written in the same transaction as the change itself. This also covers the stock
+-- updates done inside the checkout_v5 and update_stock_v1 stored procedures.
+-- The outbox only records which book changed, the synchronizer reads the current
+-- state of the book when it processes the entry.
+CREATE TRIGGER books_outbox_after_insert AFTER INSERT ON updated_edited
+FOR EACH ROW INSERT INTO books_outbox (updated_edited_id) VALUES (NEW.id);
+
+CREATE TRIGGER books_outbox_after_update AFTER UPDATE ON updated_edited
+FOR EACH ROW INSERT INTO books_outbox (updated_edited_id) VALUES (NEW.id);
+
+CREATE TRIGGER books_outbox_after_delete AFTER DELETE ON updated_edited
+FOR EACH ROW INSERT INTO books_outbox (updated_edited_id) VALUES (OLD.id);
+
+-- Queue every book for the synchronizer, which rewrites its search document from the
+-- current state of the book. Documents written before a field was added to them (like
+-- ISBN, AuthorSlug and PublisherSlug) get it filled in this way.
+--
+-- The books index rejects unknown fields, so the fields have to be in its mappings
+-- first: run cmd/reindex (with -mapping-only to keep the index) before applying this
+-- migration. Entries the synchronizer can't write yet are retried, so running it
+-- afterwards works too, just with failed attempts in the meantime.
+INSERT INTO books_outbox (updated_edited_id)
+SELECT id FROM updated_edited;
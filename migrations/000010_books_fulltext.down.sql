@@ -0,0 +1 @@
+ALTER TABLE updated_edited DROP INDEX ft_updated_edited_search;
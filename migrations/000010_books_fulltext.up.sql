@@ -0,0 +1 @@
+ALTER TABLE updated_edited ADD FULLTEXT INDEX ft_updated_edited_search (Title, Author, Publisher);
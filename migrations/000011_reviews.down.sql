@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE code = 'reviews:moderate';
+
+ALTER TABLE updated_edited
+  DROP COLUMN rating_count,
+  DROP COLUMN rating_avg;
+
+DROP TABLE IF EXISTS reviews;
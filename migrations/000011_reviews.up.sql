@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS reviews (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  user_id INT NOT NULL,
+  updated_edited_id INT UNSIGNED NOT NULL,
+  -- The order item which makes this a verified purchase.
+  order_item_id INT,
+  rating TINYINT NOT NULL,
+  body TEXT NOT NULL,
+  hidden BOOL NOT NULL DEFAULT FALSE,
+  version INT NOT NULL DEFAULT 1,
+  PRIMARY KEY (id),
+  UNIQUE KEY reviews_user_updated_edited_unique (user_id, updated_edited_id),
+  KEY idx_reviews_updated_edited (updated_edited_id, hidden, created_at),
+  CONSTRAINT fk_reviews_users FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+  CONSTRAINT fk_reviews_updated_edited FOREIGN KEY (updated_edited_id) REFERENCES updated_edited (id) ON DELETE CASCADE,
+  CONSTRAINT fk_reviews_order_items FOREIGN KEY (order_item_id) REFERENCES order_items (id) ON DELETE SET NULL,
+  CONSTRAINT reviews_rating_check CHECK (rating BETWEEN 1 AND 5)
+) ENGINE=InnoDB;
+
+-- The average rating and the number of visible reviews are kept on the book itself, so
+-- they are synced into the search index (through the books outbox) like any other
+-- column.
+ALTER TABLE updated_edited
+  ADD COLUMN rating_avg DECIMAL(3,2) NOT NULL DEFAULT 0,
+  ADD COLUMN rating_count INT NOT NULL DEFAULT 0;
+
+INSERT INTO permissions (code) VALUES ('reviews:moderate');
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS collections (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  slug VARCHAR(100) NOT NULL,
+  title VARCHAR(200) NOT NULL,
+  description TEXT NOT NULL,
+  -- The cover of a collection is the cover of one of the books, served through the
+  -- cover proxy like any other book cover.
+  cover_book_id INT UNSIGNED NULL DEFAULT NULL,
+  -- Collections are shown in ascending position on the home page.
+  position INT NOT NULL DEFAULT 0,
+  -- A collection is only shown between starts_at and ends_at, NULL means no limit.
+  starts_at TIMESTAMP NULL DEFAULT NULL,
+  ends_at TIMESTAMP NULL DEFAULT NULL,
+  version INT NOT NULL DEFAULT 1,
+  PRIMARY KEY (id),
+  UNIQUE KEY collections_slug_unique (slug),
+  CONSTRAINT fk_collections_cover_book FOREIGN KEY (cover_book_id) REFERENCES updated_edited (id) ON DELETE SET NULL
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS collection_books (
+  collection_id BIGINT NOT NULL,
+  updated_edited_id INT UNSIGNED NOT NULL,
+  position INT NOT NULL DEFAULT 0,
+  PRIMARY KEY (collection_id, updated_edited_id),
+  KEY idx_collection_books_position (collection_id, position),
+  CONSTRAINT fk_collection_books_collections FOREIGN KEY (collection_id) REFERENCES collections (id) ON DELETE CASCADE,
+  CONSTRAINT fk_collection_books_updated_edited FOREIGN KEY (updated_edited_id) REFERENCES updated_edited (id) ON DELETE CASCADE
+) ENGINE=InnoDB;
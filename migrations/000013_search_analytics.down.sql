@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'analytics:read';
+
+DROP TABLE IF EXISTS search_clicks;
+DROP TABLE IF EXISTS search_queries;
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS search_queries (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  -- search_id is handed to the client in the search response and sent back with
+  -- clicks, so clicks can be tied to the search they came from.
+  search_id CHAR(32) NOT NULL,
+  kind VARCHAR(20) NOT NULL,
+  query_text VARCHAR(255) NOT NULL,
+  -- normalized_query is query_text trimmed and lower cased, the reports group on it.
+  normalized_query VARCHAR(255) NOT NULL,
+  filters TEXT NOT NULL,
+  result_count INT NOT NULL,
+  latency_ms INT NOT NULL,
+  degraded BOOL NOT NULL DEFAULT FALSE,
+  user_id INT,
+  PRIMARY KEY (id),
+  UNIQUE KEY search_queries_search_id_unique (search_id),
+  KEY idx_search_queries_created_at (created_at, normalized_query)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS search_clicks (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  search_id CHAR(32) NOT NULL,
+  updated_edited_id INT UNSIGNED NOT NULL,
+  position INT NOT NULL,
+  PRIMARY KEY (id),
+  KEY idx_search_clicks_search_id (search_id)
+) ENGINE=InnoDB;
+
+INSERT INTO permissions (code) VALUES ('analytics:read');
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'search:write';
+
+DROP TABLE IF EXISTS search_stopwords;
+DROP TABLE IF EXISTS search_synonyms;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS search_synonyms (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  -- rule is a synonym rule in the Solr format, e.g. "js, javascript" or
+  -- "ebook => e-book".
+  rule VARCHAR(500) NOT NULL,
+  PRIMARY KEY (id)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS search_stopwords (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  word VARCHAR(100) NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY search_stopwords_word_unique (word)
+) ENGINE=InnoDB;
+
+INSERT INTO permissions (code) VALUES ('search:write');
@@ -0,0 +1,5 @@
+ALTER TABLE users
+  ADD COLUMN version INT NOT NULL DEFAULT 1,
+  -- pending_email is the address a user asked to switch to, until they confirm it
+  -- with the token sent there.
+  ADD COLUMN pending_email VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+ALTER TABLE tokens
+  DROP COLUMN ip,
+  DROP COLUMN user_agent,
+  DROP COLUMN last_used_at,
+  DROP COLUMN created_at,
+  DROP COLUMN id;
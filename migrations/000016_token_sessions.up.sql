@@ -0,0 +1,8 @@
+-- Authentication tokens double as sessions, so we keep track of where and when they
+-- are used. The id identifies a session to its user without revealing the token hash.
+ALTER TABLE tokens
+  ADD COLUMN id BIGINT NOT NULL AUTO_INCREMENT UNIQUE FIRST,
+  ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  ADD COLUMN last_used_at TIMESTAMP NULL,
+  ADD COLUMN user_agent VARCHAR(255) NOT NULL DEFAULT '',
+  ADD COLUMN ip VARCHAR(45) NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+ALTER TABLE tokens
+  DROP INDEX tokens_family_idx,
+  DROP COLUMN used_at,
+  DROP COLUMN family;
@@ -0,0 +1,8 @@
+-- A token family is an access token plus refresh token issued at login, and everything
+-- that was issued by refreshing them. used_at marks refresh tokens which have been
+-- exchanged already: presenting one of those again means it was stolen, and the whole
+-- family is revoked.
+ALTER TABLE tokens
+  ADD COLUMN family VARCHAR(32) NOT NULL DEFAULT '',
+  ADD COLUMN used_at TIMESTAMP NULL,
+  ADD INDEX tokens_family_idx (family);
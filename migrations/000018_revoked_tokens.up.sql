@@ -0,0 +1,8 @@
+-- Stateless (signed) access tokens can't be deleted, so logging out puts their id on
+-- this list until they expire. The API servers keep a copy of the list in memory.
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+  jti VARCHAR(32) NOT NULL,
+  expiry TIMESTAMP NOT NULL,
+  PRIMARY KEY (jti),
+  INDEX revoked_tokens_expiry_idx (expiry)
+) ENGINE=InnoDB;
@@ -0,0 +1,21 @@
+-- totp_secret is set when a user starts enrolling, totp_enabled once they confirmed it
+-- with a code. totp_last_step is the time step of the last accepted code, so a code
+-- can't be used twice.
+ALTER TABLE users
+  ADD COLUMN totp_secret VARCHAR(64) NOT NULL DEFAULT '',
+  ADD COLUMN totp_enabled BOOL NOT NULL DEFAULT FALSE,
+  ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  user_id INT NOT NULL,
+  hash VARBINARY(32) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  used_at TIMESTAMP NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY recovery_codes_user_hash_unique (user_id, hash),
+  CONSTRAINT fk_recovery_codes_users
+  FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+) ENGINE=InnoDB;
@@ -0,0 +1,15 @@
+-- Failed logins are tracked per account (by email, whether or not an account with that
+-- email exists), per client IP address, and for two-factor codes per user id.
+CREATE TABLE IF NOT EXISTS login_failures (
+  kind VARCHAR(16) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  failures INT NOT NULL DEFAULT 0,
+  last_failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  -- next_attempt_at enforces the growing delay between attempts, locked_until the
+  -- lockout once too many attempts failed.
+  next_attempt_at TIMESTAMP NULL,
+  locked_until TIMESTAMP NULL,
+  PRIMARY KEY (kind, subject)
+) ENGINE=InnoDB;
+
+INSERT INTO permissions (code) VALUES ('users:write');
@@ -0,0 +1,32 @@
+-- An identity is an account of a user at an OpenID Connect provider, identified by the
+-- provider's subject (sub claim). The email is the one the provider verified when the
+-- identity was linked.
+CREATE TABLE IF NOT EXISTS user_identities (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  user_id INT NOT NULL,
+  provider VARCHAR(50) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  email VARCHAR(255) NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_login_at TIMESTAMP NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY user_identities_provider_subject_unique (provider, subject),
+  CONSTRAINT fk_user_identities_users
+  FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+) ENGINE=InnoDB;
+
+-- Logins in progress at a provider, by the hash of their state parameter. They keep the
+-- nonce and the PKCE code verifier until the user comes back with the code. Logins are
+-- bound to the client which started them: it gets a state verifier along with the
+-- state and has to send it back with the code. Only its hash is stored.
+CREATE TABLE IF NOT EXISTS oidc_logins (
+  state_hash VARBINARY(32) NOT NULL,
+  state_verifier_hash VARBINARY(32) NOT NULL,
+  provider VARCHAR(50) NOT NULL,
+  nonce VARCHAR(64) NOT NULL,
+  verifier VARCHAR(128) NOT NULL,
+  expiry TIMESTAMP NOT NULL,
+  PRIMARY KEY (state_hash)
+) ENGINE=InnoDB;
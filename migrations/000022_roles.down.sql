@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS users_roles;
+
+DROP TABLE IF EXISTS role_permissions;
+
+DROP TABLE IF EXISTS roles;
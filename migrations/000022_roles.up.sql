@@ -0,0 +1,51 @@
+-- A role is a named set of permissions. The permissions of a user are the ones granted
+-- to them directly (users_permissions) plus the ones of their roles.
+CREATE TABLE IF NOT EXISTS roles (
+  id INT NOT NULL AUTO_INCREMENT,
+  code VARCHAR(50) NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY roles_code_unique (code)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+  role_id INT NOT NULL,
+  permission_id INT NOT NULL,
+  CONSTRAINT fk_role_permissions_roles
+  FOREIGN KEY (role_id)
+    REFERENCES roles(id)
+    ON DELETE CASCADE,
+  CONSTRAINT fk_role_permissions_permissions
+  FOREIGN KEY (permission_id)
+    REFERENCES permissions(id)
+    ON DELETE CASCADE,
+  PRIMARY KEY (role_id, permission_id)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS users_roles (
+  user_id INT NOT NULL,
+  role_id INT NOT NULL,
+  CONSTRAINT fk_users_roles_users
+  FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  CONSTRAINT fk_users_roles_roles
+  FOREIGN KEY (role_id)
+    REFERENCES roles(id)
+    ON DELETE CASCADE,
+  PRIMARY KEY (user_id, role_id)
+) ENGINE=InnoDB;
+
+INSERT INTO roles (code) VALUES ('customer'), ('staff'), ('admin');
+
+-- Customers can use the shop, staff run the catalog, and admins can do everything,
+-- including managing the roles and permissions of users.
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE (roles.code = 'customer' AND permissions.code IN ('books:read'))
+  OR (roles.code = 'staff' AND permissions.code IN ('books:read', 'books:write', 'reviews:moderate', 'search:write', 'analytics:read'))
+  OR roles.code = 'admin';
+
+-- Every existing user is a customer.
+INSERT INTO users_roles (user_id, role_id)
+SELECT users.id, roles.id FROM users, roles
+WHERE roles.code = 'customer';
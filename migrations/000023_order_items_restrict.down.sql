@@ -0,0 +1,8 @@
+ALTER TABLE order_items
+  DROP FOREIGN KEY fk_order_items_updated_edited;
+
+ALTER TABLE order_items
+  ADD CONSTRAINT fk_order_items_updated_edited
+  FOREIGN KEY (updated_edited_id)
+    REFERENCES updated_edited(id)
+    ON DELETE CASCADE;
@@ -0,0 +1,9 @@
+-- Deleting a book must not take the order history with it, see BookModel.Delete.
+ALTER TABLE order_items
+  DROP FOREIGN KEY fk_order_items_updated_edited;
+
+ALTER TABLE order_items
+  ADD CONSTRAINT fk_order_items_updated_edited
+  FOREIGN KEY (updated_edited_id)
+    REFERENCES updated_edited(id)
+    ON DELETE RESTRICT;
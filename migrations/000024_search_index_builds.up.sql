@@ -0,0 +1,10 @@
+-- Indices cmd/reindex is loading. The synchronizers write every change to them as well
+-- as to the live index, so nothing that changes during the rebuild is lost at the
+-- alias swap. Builds which failed or were cancelled are kept with their failed_at set
+-- until the next build starts, they don't count as running anymore.
+CREATE TABLE IF NOT EXISTS search_index_builds (
+  index_name VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  failed_at TIMESTAMP NULL,
+  PRIMARY KEY (index_name)
+) ENGINE=InnoDB;
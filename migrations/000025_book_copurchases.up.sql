@@ -0,0 +1,19 @@
+-- How many orders every pair of books was bought together in, for the "customers also
+-- bought" rail. Counting the pairs from order_items on every request gets slow as the
+-- order history grows, so the API recomputes this table in the background instead, see
+-- BookModel.RefreshAlsoBought. Every pair is stored both ways round.
+CREATE TABLE IF NOT EXISTS book_copurchases (
+  updated_edited_id INT UNSIGNED NOT NULL,
+  other_id INT UNSIGNED NOT NULL,
+  orders INT NOT NULL,
+  PRIMARY KEY (updated_edited_id, other_id),
+  KEY idx_book_copurchases_orders (updated_edited_id, orders),
+  CONSTRAINT fk_book_copurchases_updated_edited
+  FOREIGN KEY (updated_edited_id)
+    REFERENCES updated_edited(id)
+    ON DELETE CASCADE,
+  CONSTRAINT fk_book_copurchases_other
+  FOREIGN KEY (other_id)
+    REFERENCES updated_edited(id)
+    ON DELETE CASCADE
+) ENGINE=InnoDB;
@@ -0,0 +1,11 @@
+-- Revoking every signed access token of a user (password reset, logging out everywhere,
+-- role changes) by id would need the ids of tokens we never stored. Instead, tokens of
+-- the user issued before revoked_before are rejected. The entry can go once every such
+-- token has expired, at expiry.
+CREATE TABLE IF NOT EXISTS revoked_users (
+  user_id INT NOT NULL,
+  revoked_before TIMESTAMP NOT NULL,
+  expiry TIMESTAMP NOT NULL,
+  PRIMARY KEY (user_id),
+  INDEX revoked_users_expiry_idx (expiry)
+) ENGINE=InnoDB;